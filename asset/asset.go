@@ -3,26 +3,61 @@
 package asset
 
 import (
+	"bytes"
 	"crypto/md5"
 	"fmt"
 	"image"
-	"image/jpeg"
-	"io"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
 
 	log "github.com/gpitfield/relog"
-	"github.com/nfnt/resize"
 	"github.com/spf13/viper"
 )
 
+// cutVersion is bumped whenever a change to how images are cut means existing cuts should be replaced
+const cutVersion = 4
+
 type SrcImage struct {
 	Name   string
 	Bounds image.Rectangle
 	Suffix string
-	XVal   string
 	WVal   string
+	MIME   string
+	Crop   bool // whether this is a fixed-aspect crop for grids rather than the whole image
+}
+
+// SrcSet is the renditions of an image in a single format
+type SrcSet struct {
+	MIME   string
+	Images []SrcImage
+}
+
+// Group splits srcs into the JPEG renditions every browser can show, and a SrcSet per additional format. Only crops
+// are included if crop is true, and only whole images otherwise.
+func Group(srcs []SrcImage, crop bool) (fallback []SrcImage, sources []SrcSet) {
+	for _, src := range srcs {
+		if src.Crop != crop {
+			continue
+		}
+		if src.MIME == JPEG.MIME || src.MIME == "" {
+			fallback = append(fallback, src)
+			continue
+		}
+		found := false
+		for i := range sources {
+			if sources[i].MIME == src.MIME {
+				sources[i].Images = append(sources[i].Images, src)
+				found = true
+				break
+			}
+		}
+		if !found {
+			sources = append(sources, SrcSet{MIME: src.MIME, Images: []SrcImage{src}})
+		}
+	}
+	return
 }
 
 func Hash(asset []byte) string {
@@ -31,6 +66,40 @@ func Hash(asset []byte) string {
 	return fmt.Sprintf("%x", hash.Sum(nil))
 }
 
+// Fingerprint returns a hash of the config values that affect how images are cut, so that changing any of them
+// causes previously cut images to be re-cut. The watermark config is only included for images that are watermarked, and
+// the focus of an image's crops only if crops are configured.
+func Fingerprint(watermarked bool, focus *Focus) string {
+	config := fmt.Sprintf("v%d jpeg-quality=%d %s %s", cutVersion, viper.GetInt(JPEG_QUALITY), ladderFingerprint(), metadataFingerprint())
+	config += " " + colorFingerprint() + cropFingerprint(focus)
+	if watermarked {
+		config += watermarkFingerprint()
+	}
+	for _, format := range OutputFormats() {
+		config += fmt.Sprintf(" %s=%d:%s", format.Name, format.quality(), format.command())
+	}
+	return Hash([]byte(config))
+}
+
+// CopyImage publishes a copy of the image at inPath to outPath, with its metadata rewritten according to the metadata
+// policy if it is a JPEG. Images that are converted to sRGB are re-encoded as JPEG instead.
+func CopyImage(inPath, outPath string) (err error) {
+	source, err := ioutil.ReadFile(inPath)
+	if err != nil {
+		return
+	}
+	meta := ReadMetadata(source)
+	if !meta.converts() {
+		return ioutil.WriteFile(outPath, meta.Rewrite(source), 0644)
+	}
+	img, _, err := image.Decode(bytes.NewReader(source))
+	if err != nil {
+		return
+	}
+	img, meta = toSRGB(img, meta)
+	return JPEG.Encode(img, outPath, 0, meta)
+}
+
 // Given an image filename, decode its "real" name, order position, whether it is a cover image, and if it's untitled
 func FileInfo(filename string) (name string, order int, cover bool, untitled bool) {
 	name = filename
@@ -55,80 +124,169 @@ func FileInfo(filename string) (name string, order int, cover bool, untitled boo
 	return
 }
 
-// RespImages return a slice of the SrcImages the given image should be resized to
-func RespImages(inPath string, outDir string, baseName string, extension string, metaOnly bool) (srcSet []SrcImage) {
+// RespImages return a slice of the SrcImages the given image should be resized to, once turned upright according to
+// its EXIF orientation, along with its preview unless metaOnly. Images in formats other than JPEG, or that need
+// turning, are published as upright JPEGs rather than copied as is, as are those converted to sRGB. If watermarked is
+// true, the configured watermark is applied to those at least watermark.min-width wide. If crops are configured, a set
+// of cropped SrcImages is added around focus, or the most detailed part of the image if focus is nil.
+func RespImages(inPath string, outDir string, baseName string, extension string, orientation int, focus *Focus, watermarked, metaOnly bool) (srcSet []SrcImage, preview Preview) {
 	var (
 		bounds image.Rectangle
 		img    image.Image
+		format string
 		err    error
 	)
-	in, err := os.Open(inPath)
-	if err != nil {
-		log.Error(err)
-	}
-	defer in.Close()
+	var (
+		source    []byte
+		meta      Metadata
+		converted bool
+	)
 	if metaOnly {
-		cfg, _, err := image.DecodeConfig(in)
+		in, err := os.Open(inPath)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		defer in.Close()
+		var cfg image.Config
+		cfg, format, err = image.DecodeConfig(in)
 		if err != nil {
 			log.Error(err)
 		}
-		bounds = image.Rect(0, 0, cfg.Width, cfg.Height)
+		bounds = OrientedBounds(image.Rect(0, 0, cfg.Width, cfg.Height), orientation)
 	} else {
-		img, _, err = image.Decode(in)
+		source, err = ioutil.ReadFile(inPath)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		meta = ReadMetadata(source)
+		img, format, err = image.Decode(bytes.NewReader(source))
 		if err != nil {
 			log.Error(err)
+			return
 		}
+		converted = meta.converts()
+		img, meta = toSRGB(img, meta)
+		img = Orient(img, orientation)
 		bounds = img.Bounds()
+		preview = NewPreview(img)
 	}
+	if format != JPEG.Name {
+		extension = JPEG.Extension // other formats are published as JPEG
+	}
+	c := cutter{
+		outDir:      outDir,
+		baseName:    baseName,
+		extension:   extension,
+		formats:     OutputFormats(),
+		meta:        meta,
+		watermarked: watermarked && Watermarking(),
+		metaOnly:    metaOnly,
+	}
+	full := img
+	if c.watermarked && !metaOnly {
+		full = watermark(img)
+	}
+	exact := format == JPEG.Name && orientation <= 1 && !converted && !(c.watermarked && watermarks(bounds.Dx()))
 	srcSet = []SrcImage{SrcImage{
 		Bounds: bounds,
 		Suffix: "",
-		XVal:   "3x", // TBD how we do this
 		WVal:   fmt.Sprintf("%dw", bounds.Max.X),
 		Name:   baseName + extension,
+		MIME:   JPEG.MIME,
 	}}
-	suffix := 1
-	if !metaOnly {
-		inCopy, err := os.Open(inPath)
-		if err != nil {
+	if !metaOnly && !exact {
+		if err = JPEG.Encode(full, outDir+"/"+srcSet[0].Name, 0, meta); err != nil {
 			log.Error(err)
 		}
-		defer inCopy.Close()
-		outFile, err := os.Create(outDir + "/" + baseName + extension)
-		if err != nil {
+	} else if !metaOnly {
+		// copy the original pixels exactly, only rewriting its metadata as the policy requires
+		if err = ioutil.WriteFile(outDir+"/"+srcSet[0].Name, meta.Rewrite(source), 0644); err != nil {
 			log.Error(err)
 		}
-		_, err = io.Copy(outFile, inCopy) // use an exact copy so the hash doesn't mutate
-		if err != nil {
-			log.Error(err)
+	}
+	srcSet = append(srcSet, c.alternates(full, srcSet[0])...)
+	srcSet = append(srcSet, c.ladder(img, bounds, SrcImage{})...)
+
+	if aspect := cropAspect(); cropsImage(bounds, aspect) {
+		crop := cropRect(img, bounds, aspect, focus)
+		var cropped image.Image
+		if !metaOnly {
+			cropped = cropImage(img, crop)
+		}
+		src := SrcImage{
+			Bounds: image.Rect(0, 0, crop.Dx(), crop.Dy()),
+			Suffix: cropSuffix,
+			WVal:   fmt.Sprintf("%dw", crop.Dx()),
+			Name:   baseName + cropSuffix + extension,
+			MIME:   JPEG.MIME,
+			Crop:   true,
 		}
-		outFile.Close()
+		srcSet = append(srcSet, c.cut(cropped, src, 0)...)
+		srcSet = append(srcSet, c.ladder(cropped, src.Bounds, src)...)
 	}
+	return
+}
+
+// cutter encodes the renditions of a single image
+type cutter struct {
+	outDir      string
+	baseName    string
+	extension   string
+	formats     []Format
+	meta        Metadata
+	watermarked bool
+	metaOnly    bool
+}
 
-	for bounds.Max.X > 100 {
-		suffix *= 2
-		bounds.Max.X /= 2
-		bounds.Max.Y /= 2
+// ladder returns the renditions of the ladder for the upright image img, resizing and encoding them unless metaOnly.
+// The renditions take the suffix and crop flag of base.
+func (c cutter) ladder(img image.Image, bounds image.Rectangle, base SrcImage) (srcSet []SrcImage) {
+	for _, rendition := range Ladder(bounds) {
 		src := SrcImage{
-			Bounds: bounds,
-			Suffix: fmt.Sprintf("_%d", suffix),
-			XVal:   "tbd",
-			WVal:   fmt.Sprintf("%dw", bounds.Max.X),
+			Bounds: image.Rect(0, 0, rendition.Width, rendition.Height(bounds)),
+			Suffix: base.Suffix + rendition.Suffix(),
+			WVal:   fmt.Sprintf("%dw", rendition.Width),
+			MIME:   JPEG.MIME,
+			Crop:   base.Crop,
 		}
-		src.Name = baseName + src.Suffix + extension
-		srcSet = append(srcSet, src)
-		if !metaOnly {
-			newImage := resize.Resize(uint(bounds.Max.X), uint(bounds.Max.Y), img, resize.Lanczos3)
-			outFile, err := os.Create(outDir + "/" + src.Name)
-			if err != nil {
-				log.Error(err)
-			}
-			err = jpeg.Encode(outFile, newImage, &jpeg.Options{Quality: viper.GetInt("jpeg-quality")})
-			if err != nil {
+		src.Name = c.baseName + src.Suffix + c.extension
+		var resized image.Image
+		if !c.metaOnly {
+			resized = rendition.Cut(img)
+		}
+		srcSet = append(srcSet, c.cut(resized, src, rendition.Quality)...)
+	}
+	return
+}
+
+// cut returns src along with its alternates, watermarking and encoding img as each of them unless metaOnly
+func (c cutter) cut(img image.Image, src SrcImage, quality int) []SrcImage {
+	if !c.metaOnly {
+		if c.watermarked {
+			img = watermark(img)
+		}
+		if err := JPEG.Encode(img, c.outDir+"/"+src.Name, quality, c.meta); err != nil {
+			log.Error(err)
+		}
+	}
+	return append([]SrcImage{src}, c.alternates(img, src)...)
+}
+
+// alternates returns the renditions of img in each additional format at the size of src, encoding them unless metaOnly
+func (c cutter) alternates(img image.Image, src SrcImage) (srcSet []SrcImage) {
+	for _, format := range c.formats {
+		alt := src
+		alt.Name = c.baseName + src.Suffix + format.Extension
+		alt.MIME = format.MIME
+		if !c.metaOnly {
+			if err := format.Encode(img, c.outDir+"/"+alt.Name, 0, c.meta); err != nil {
 				log.Error(err)
+				continue
 			}
-			outFile.Close()
 		}
+		srcSet = append(srcSet, alt)
 	}
 	return
 }
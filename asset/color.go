@@ -0,0 +1,353 @@
+package asset
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	COLOR_PROFILE = "color.profile"
+
+	PreserveProfile = "preserve" // embed the source's ICC profile in every published image
+	ConvertToSRGB   = "srgb"     // convert pixels from the source's ICC profile to sRGB, and embed no profile
+)
+
+const (
+	tagICCProfile = 0x8773
+	iccChunkSize  = 0xffff - 2 - 14 // ICC_PROFILE identifier, sequence number and count take 14 bytes of each segment
+)
+
+var errProfile = errors.New("unsupported ICC profile")
+
+// srgbColorants are the red, green and blue XYZ colorants of sRGB, adapted to the D50 white of the profile connection
+// space as they appear in sRGB ICC profiles
+var srgbColorants = [3][3]float64{
+	{0.4360747, 0.2225045, 0.0139322},
+	{0.3850649, 0.7168786, 0.0971045},
+	{0.1430804, 0.0606169, 0.7141733},
+}
+
+// profile is a matrix/TRC RGB ICC profile, the kind used by Adobe RGB, Display P3, ProPhoto and most camera exports
+type profile struct {
+	colorants [3][3]float64            // XYZ of the red, green and blue primaries
+	curves    [3]func(float64) float64 // tone response of each channel, from encoded to linear
+}
+
+// readICC reads the ICC profile of a source image: the APP2 segments of a JPEG, the iCCP chunk of a PNG, the ICCP
+// chunk of a WebP or the ICC tag of a TIFF
+func readICC(source []byte, segments []jpegSegment) []byte {
+	if segments != nil {
+		var chunks [][]byte
+		for _, seg := range segments {
+			data := seg.payload()
+			if seg.marker != markerAPP2 || !bytes.HasPrefix(data, iccHeader) || len(data) < len(iccHeader)+2 {
+				continue
+			}
+			seq, count := int(data[len(iccHeader)]), int(data[len(iccHeader)+1])
+			if chunks == nil {
+				chunks = make([][]byte, count)
+			}
+			if seq < 1 || seq > len(chunks) {
+				return nil
+			}
+			chunks[seq-1] = data[len(iccHeader)+2:]
+		}
+		return bytes.Join(chunks, nil)
+	}
+	switch {
+	case bytes.HasPrefix(source, pngSignature):
+		r := chunk(bytes.NewReader(source), len(pngSignature), "iCCP", binary.BigEndian, false)
+		if r == nil {
+			return nil
+		}
+		data, _ := ioutil.ReadAll(r)
+		name := bytes.IndexByte(data, 0)
+		if name < 0 || name+2 > len(data) {
+			return nil
+		}
+		z, err := zlib.NewReader(bytes.NewReader(data[name+2:]))
+		if err != nil {
+			return nil
+		}
+		icc, _ := ioutil.ReadAll(z)
+		return icc
+	case bytes.HasPrefix(source, riffHeader):
+		if r := chunk(bytes.NewReader(source), 12, "ICCP", binary.LittleEndian, true); r != nil {
+			icc, _ := ioutil.ReadAll(r)
+			return icc
+		}
+	default:
+		if _, ifd0, err := parseTIFF(source); err == nil {
+			return ifd0.value(tagICCProfile)
+		}
+	}
+	return nil
+}
+
+// iccSegments returns the APP2 segments that embed an ICC profile in a JPEG
+func iccSegments(icc []byte) (segs [][]byte) {
+	count := (len(icc) + iccChunkSize - 1) / iccChunkSize
+	if count > 255 {
+		return
+	}
+	for i := 0; i < count; i++ {
+		end := (i + 1) * iccChunkSize
+		if end > len(icc) {
+			end = len(icc)
+		}
+		payload := append(append([]byte{}, iccHeader...), byte(i+1), byte(count))
+		segs = appendSegment(segs, markerAPP2, append(payload, icc[i*iccChunkSize:end]...))
+	}
+	return
+}
+
+// iccPNG inserts an iCCP chunk holding the ICC profile after the header chunk of an encoded PNG
+func iccPNG(encoded, icc []byte) []byte {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4 // signature, then the length, type, data and CRC of IHDR
+	if len(icc) == 0 || len(encoded) < ihdrEnd {
+		return encoded
+	}
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write(icc)
+	w.Close()
+	data := append([]byte("ICC Profile\x00\x00"), z.Bytes()...)
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk[0:4], uint32(len(data)))
+	copy(chunk[4:8], "iCCP")
+	chunk = append(chunk, data...)
+	chunk = append(chunk, make([]byte, 4)...)
+	binary.BigEndian.PutUint32(chunk[len(chunk)-4:], crc32.ChecksumIEEE(chunk[4:len(chunk)-4]))
+	out := append(append([]byte{}, encoded[:ihdrEnd]...), chunk...)
+	return append(out, encoded[ihdrEnd:]...)
+}
+
+// toSRGB converts img from the ICC profile in meta to sRGB if the color profile config asks for it, returning the
+// metadata without the profile once converted. Images without a profile, or that are already sRGB, are left as is.
+func toSRGB(img image.Image, meta Metadata) (image.Image, Metadata) {
+	if !meta.converts() {
+		return img, meta
+	}
+	p, _ := parseProfile(meta.icc)
+	meta.icc = nil
+	return p.convert(img), meta
+}
+
+// converts reports whether the image's pixels are converted to sRGB before publishing
+func (m Metadata) converts() bool {
+	if colorProfile() != ConvertToSRGB || len(m.icc) == 0 {
+		return false
+	}
+	p, err := parseProfile(m.icc)
+	if err != nil {
+		warnOnce("profile-"+Hash(m.icc), "not converting images to sRGB: %s", err)
+		return false
+	}
+	return !p.sRGB()
+}
+
+// parseProfile reads the primaries and tone curves of a matrix/TRC RGB profile
+func parseProfile(icc []byte) (p profile, err error) {
+	if len(icc) < 132 || string(icc[16:20]) != "RGB " || string(icc[20:24]) != "XYZ " {
+		return p, errProfile
+	}
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(icc[128:132]))
+	for i := 0; i < count && 132+i*12+12 <= len(icc); i++ {
+		entry := icc[132+i*12:]
+		offset, size := binary.BigEndian.Uint32(entry[4:8]), binary.BigEndian.Uint32(entry[8:12])
+		if uint64(offset)+uint64(size) <= uint64(len(icc)) {
+			tags[string(entry[0:4])] = icc[offset : offset+size]
+		}
+	}
+	for i, c := range []string{"r", "g", "b"} {
+		xyz := tags[c+"XYZ"]
+		if len(xyz) < 20 || string(xyz[0:4]) != "XYZ " {
+			return p, fmt.Errorf("%s: missing %sXYZ", errProfile, c)
+		}
+		for j := 0; j < 3; j++ {
+			p.colorants[i][j] = s15Fixed16(xyz[8+j*4:])
+		}
+		if p.curves[i], err = parseCurve(tags[c+"TRC"]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// parseCurve reads a curv or para tone curve, returning it as a function from an encoded value to a linear one
+func parseCurve(b []byte) (func(float64) float64, error) {
+	if len(b) < 12 {
+		return nil, fmt.Errorf("%s: missing tone curve", errProfile)
+	}
+	switch string(b[0:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(b[8:12]))
+		switch {
+		case n == 0:
+			return func(v float64) float64 { return v }, nil
+		case n == 1 && len(b) >= 14:
+			gamma := float64(binary.BigEndian.Uint16(b[12:14])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		case len(b) >= 12+2*n:
+			table := make([]float64, n)
+			for i := range table {
+				table[i] = float64(binary.BigEndian.Uint16(b[12+2*i:])) / 65535
+			}
+			return func(v float64) float64 {
+				at := v * float64(n-1)
+				i := int(at)
+				if i >= n-1 {
+					return table[n-1]
+				}
+				return table[i] + (table[i+1]-table[i])*(at-float64(i))
+			}, nil
+		}
+	case "para":
+		counts := []int{1, 3, 4, 5, 7}
+		kind := int(binary.BigEndian.Uint16(b[8:10]))
+		if kind >= len(counts) || len(b) < 12+4*counts[kind] {
+			break
+		}
+		var g [7]float64
+		for i := 0; i < counts[kind]; i++ {
+			g[i] = s15Fixed16(b[12+4*i:])
+		}
+		return func(v float64) float64 {
+			switch kind {
+			case 0:
+				return math.Pow(v, g[0])
+			case 1:
+				if v >= -g[2]/g[1] {
+					return math.Pow(g[1]*v+g[2], g[0])
+				}
+				return 0
+			case 2:
+				if v >= -g[2]/g[1] {
+					return math.Pow(g[1]*v+g[2], g[0]) + g[3]
+				}
+				return g[3]
+			case 3:
+				if v >= g[4] {
+					return math.Pow(g[1]*v+g[2], g[0])
+				}
+				return g[3] * v
+			}
+			if v >= g[4] {
+				return math.Pow(g[1]*v+g[2], g[0]) + g[5]
+			}
+			return g[3]*v + g[6]
+		}, nil
+	}
+	return nil, fmt.Errorf("%s: unreadable tone curve", errProfile)
+}
+
+// sRGB reports whether the profile's primaries are those of sRGB
+func (p profile) sRGB() bool {
+	for i := range p.colorants {
+		for j := range p.colorants[i] {
+			if math.Abs(p.colorants[i][j]-srgbColorants[i][j]) > 0.002 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// convert returns img with its pixels converted from the profile to sRGB, clipping colors outside of sRGB
+func (p profile) convert(img image.Image) image.Image {
+	// map each channel of the source to linear light, then the source primaries to those of sRGB
+	var (
+		linear [3][256]float64
+		m      = multiply(invert(transpose(srgbColorants)), transpose(p.colorants))
+		encode [4096]uint8
+	)
+	for c := range linear {
+		for v := range linear[c] {
+			linear[c][v] = p.curves[c](float64(v) / 255)
+		}
+	}
+	for i := range encode {
+		v := float64(i) / float64(len(encode)-1)
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		encode[i] = clamp(v * 255)
+	}
+	bounds := img.Bounds()
+	out := image.NewNRGBA(bounds)
+	draw.Draw(out, bounds, img, bounds.Min, draw.Src)
+	for i := 0; i < len(out.Pix); i += 4 {
+		r, g, b := linear[0][out.Pix[i]], linear[1][out.Pix[i+1]], linear[2][out.Pix[i+2]]
+		for c := 0; c < 3; c++ {
+			v := m[c][0]*r + m[c][1]*g + m[c][2]*b
+			out.Pix[i+c] = encode[int(math.Max(0, math.Min(1, v))*float64(len(encode)-1)+0.5)]
+		}
+	}
+	return out
+}
+
+// colorProfile returns the configured handling of ICC profiles, which by default are preserved
+func colorProfile() string {
+	if strings.ToLower(viper.GetString(COLOR_PROFILE)) == ConvertToSRGB {
+		return ConvertToSRGB
+	}
+	return PreserveProfile
+}
+
+// colorFingerprint describes the color config for Fingerprint
+func colorFingerprint() string {
+	return "color=" + colorProfile()
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// transpose turns rows of colorants into a matrix whose columns are the colorants
+func transpose(a [3][3]float64) (t [3][3]float64) {
+	for i := range a {
+		for j := range a[i] {
+			t[j][i] = a[i][j]
+		}
+	}
+	return
+}
+
+func multiply(a, b [3][3]float64) (m [3][3]float64) {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return
+}
+
+func invert(a [3][3]float64) (inv [3][3]float64) {
+	det := a[0][0]*(a[1][1]*a[2][2]-a[1][2]*a[2][1]) - a[0][1]*(a[1][0]*a[2][2]-a[1][2]*a[2][0]) +
+		a[0][2]*(a[1][0]*a[2][1]-a[1][1]*a[2][0])
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// cofactor of a[j][i], transposed into place
+			r0, r1 := (j+1)%3, (j+2)%3
+			c0, c1 := (i+1)%3, (i+2)%3
+			inv[i][j] = (a[r0][c0]*a[r1][c1] - a[r0][c1]*a[r1][c0]) / det
+		}
+	}
+	return
+}
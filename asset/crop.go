@@ -0,0 +1,199 @@
+package asset
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+	"github.com/spf13/viper"
+)
+
+const (
+	CROPS_ASPECT = "crops.aspect"
+	CROPS_SMART  = "crops.smart"
+)
+
+const (
+	cropSuffix     = "_crop"
+	energyWidth    = 64   // width images are scaled to when looking for their most detailed part
+	aspectTolerant = 0.01 // images within this fraction of the crop aspect are used whole
+)
+
+// Focus is the point of an image that its crops are centered on, as fractions of its width and height from the top
+// left
+type Focus struct {
+	X float64 `yaml:"x" json:"x"`
+	Y float64 `yaml:"y" json:"y"`
+}
+
+// String describes the focus for Fingerprint
+func (f *Focus) String() string {
+	if f == nil {
+		return "auto"
+	}
+	return fmt.Sprintf("%v,%v", f.X, f.Y)
+}
+
+// cropAspect returns the configured width to height ratio of crops, written as 1:1, 4:3 or 1.5, or 0 if crops aren't
+// configured
+func cropAspect() float64 {
+	value := strings.TrimSpace(viper.GetString(CROPS_ASPECT))
+	if value == "" {
+		return 0
+	}
+	if parts := strings.SplitN(value, ":", 2); len(parts) == 2 {
+		w, werr := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		h, herr := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if werr == nil && herr == nil && w > 0 && h > 0 {
+			return w / h
+		}
+	} else if aspect, err := strconv.ParseFloat(value, 64); err == nil && aspect > 0 {
+		return aspect
+	}
+	warnOnce("crops-aspect", "ignoring invalid crop aspect %s", value)
+	return 0
+}
+
+// cropsImage reports whether an image of the given bounds is cropped to aspect, which it isn't if it is already close
+func cropsImage(bounds image.Rectangle, aspect float64) bool {
+	if aspect <= 0 || bounds.Empty() {
+		return false
+	}
+	return math.Abs(float64(bounds.Dx())/float64(bounds.Dy())/aspect-1) > aspectTolerant
+}
+
+// smartCrops reports whether images without a focus are cropped around their most detailed part rather than centered
+func smartCrops() bool {
+	return !viper.IsSet(CROPS_SMART) || viper.GetBool(CROPS_SMART)
+}
+
+// cropRect returns the largest rectangle of the given aspect within bounds, centered on the focus if there is one, or
+// the most detailed part of img otherwise. If img is nil only the size of the rectangle is meaningful.
+func cropRect(img image.Image, bounds image.Rectangle, aspect float64, focus *Focus) image.Rectangle {
+	size := image.Pt(bounds.Dx(), int(math.Round(float64(bounds.Dx())/aspect)))
+	if size.Y > bounds.Dy() {
+		size = image.Pt(int(math.Round(float64(bounds.Dy())*aspect)), bounds.Dy())
+	}
+	center := image.Pt(bounds.Min.X+bounds.Dx()/2, bounds.Min.Y+bounds.Dy()/2)
+	switch {
+	case focus != nil:
+		center = image.Pt(bounds.Min.X+int(focus.X*float64(bounds.Dx())), bounds.Min.Y+int(focus.Y*float64(bounds.Dy())))
+	case img != nil && smartCrops():
+		center = detailCenter(img, size)
+	}
+	min := center.Sub(size.Div(2))
+	min.X = clampInt(min.X, bounds.Min.X, bounds.Max.X-size.X)
+	min.Y = clampInt(min.Y, bounds.Min.Y, bounds.Max.Y-size.Y)
+	return image.Rectangle{min, min.Add(size)}
+}
+
+// cropImage returns the part of img within rect, with its origin at zero
+func cropImage(img image.Image, rect image.Rectangle) image.Image {
+	out := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(out, out.Bounds(), img, rect.Min, draw.Src)
+	return out
+}
+
+// detailCenter returns the center of the window of the given size, sliding along whichever axis it is shorter than
+// img, that has the most edge energy. Windows nearer the center win ties, and are slightly favored otherwise.
+func detailCenter(img image.Image, size image.Point) image.Point {
+	bounds := img.Bounds()
+	scale := float64(bounds.Dx()) / energyWidth
+	small := resize.Resize(energyWidth, 0, img, resize.Bilinear)
+	energy := edgeEnergy(small)
+	w, h := len(energy[0]), len(energy)
+	win := image.Pt(int(math.Round(float64(size.X)/scale)), int(math.Round(float64(size.Y)/scale)))
+	win.X, win.Y = clampInt(win.X, 1, w), clampInt(win.Y, 1, h)
+
+	// sums of energy along each row and column, so each window's energy is a sum over the sliding axis
+	var line []float64
+	horizontal := w-win.X > h-win.Y
+	if horizontal {
+		line = make([]float64, w)
+		for y := range energy {
+			for x, e := range energy[y] {
+				line[x] += e
+			}
+		}
+	} else {
+		line = make([]float64, h)
+		for y := range energy {
+			for _, e := range energy[y] {
+				line[y] += e
+			}
+		}
+	}
+	length := win.Y
+	if horizontal {
+		length = win.X
+	}
+	best, bestScore := (len(line)-length)/2, -1.0
+	for start := 0; start+length <= len(line); start++ {
+		var sum float64
+		for _, e := range line[start : start+length] {
+			sum += e
+		}
+		offCenter := math.Abs(float64(start)-float64(len(line)-length)/2) / float64(len(line))
+		if score := sum * (1 - 0.2*offCenter); score > bestScore {
+			best, bestScore = start, score
+		}
+	}
+	center := image.Pt(bounds.Min.X+bounds.Dx()/2, bounds.Min.Y+bounds.Dy()/2)
+	at := bounds.Min.X
+	if !horizontal {
+		at = bounds.Min.Y
+	}
+	mid := at + int((float64(best)+float64(length)/2)*scale)
+	if horizontal {
+		center.X = mid
+	} else {
+		center.Y = mid
+	}
+	return center
+}
+
+// edgeEnergy returns the luminance gradient magnitude of each pixel of img, indexed by row then column
+func edgeEnergy(img image.Image) [][]float64 {
+	bounds := img.Bounds()
+	lum := make([][]float64, bounds.Dy())
+	for y := range lum {
+		lum[y] = make([]float64, bounds.Dx())
+		for x := range lum[y] {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			lum[y][x] = 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+		}
+	}
+	energy := make([][]float64, len(lum))
+	for y := range lum {
+		energy[y] = make([]float64, len(lum[y]))
+		for x := range lum[y] {
+			dx := lum[y][clampInt(x+1, 0, len(lum[y])-1)] - lum[y][clampInt(x-1, 0, len(lum[y])-1)]
+			dy := lum[clampInt(y+1, 0, len(lum)-1)][x] - lum[clampInt(y-1, 0, len(lum)-1)][x]
+			energy[y][x] = math.Abs(dx) + math.Abs(dy)
+		}
+	}
+	return energy
+}
+
+// cropFingerprint describes the crop config for Fingerprint, along with the focus of the image's crops
+func cropFingerprint(focus *Focus) string {
+	aspect := cropAspect()
+	if aspect <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" crop=%v smart=%t focus=%s", aspect, smartCrops(), focus)
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,147 @@
+package asset
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/gpitfield/relog"
+	"github.com/spf13/viper"
+)
+
+const (
+	FORMATS      = "formats"
+	JPEG_QUALITY = "jpeg-quality"
+)
+
+// Format is an image format that renditions are encoded in
+type Format struct {
+	Name      string
+	Extension string
+	MIME      string
+	Command   string // default external encoder, with {quality}, {in} and {out} placeholders; overridden by <name>-command
+}
+
+// JPEG is the format every rendition is always cut in, and the fallback for browsers without support for the others
+var JPEG = Format{Name: "jpeg", Extension: ".jpg", MIME: "image/jpeg"}
+
+// Formats are the additional formats that may be listed in the formats config value
+var Formats = map[string]Format{
+	"webp": {Name: "webp", Extension: ".webp", MIME: "image/webp", Command: "cwebp -quiet -metadata icc -q {quality} {in} -o {out}"},
+	"avif": {Name: "avif", Extension: ".avif", MIME: "image/avif", Command: "avifenc -q {quality} {in} {out}"},
+}
+
+var (
+	warned   = map[string]bool{}
+	warnedMu sync.Mutex
+)
+
+// OutputFormats returns the configured additional formats, skipping any that are unknown. CheckFormats reports those
+// that are unknown or can't be encoded.
+func OutputFormats() (formats []Format) {
+	for _, name := range viper.GetStringSlice(FORMATS) {
+		if format, ok := Formats[strings.ToLower(name)]; ok {
+			formats = append(formats, format)
+		}
+	}
+	return
+}
+
+// CheckFormats returns an error if any of the configured additional formats is unknown, or its encoder isn't installed,
+// so that a build doesn't publish pages without the formats they were configured to offer
+func CheckFormats() error {
+	for _, name := range viper.GetStringSlice(FORMATS) {
+		name = strings.ToLower(name)
+		if name == JPEG.Name || name == "jpg" {
+			continue
+		}
+		format, ok := Formats[name]
+		if !ok {
+			return fmt.Errorf("unknown image format %s in %s", name, FORMATS)
+		}
+		args := strings.Fields(format.command())
+		if len(args) == 0 {
+			return fmt.Errorf("no encoder for %s images, set %s-command", name, name)
+		}
+		if _, err := exec.LookPath(args[0]); err != nil {
+			return fmt.Errorf("can't generate %s images listed in %s, install %s or set %s-command: %s", name, FORMATS,
+				args[0], name, err.Error())
+		}
+	}
+	return nil
+}
+
+// command returns the configured encoder command for the format
+func (f Format) command() string {
+	if cmd := viper.GetString(f.Name + "-command"); cmd != "" {
+		return cmd
+	}
+	return f.Command
+}
+
+// quality returns the configured quality for the format, defaulting to the JPEG quality
+func (f Format) quality() int {
+	if q := viper.GetInt(f.Name + "-quality"); q > 0 {
+		return q
+	}
+	return viper.GetInt(JPEG_QUALITY)
+}
+
+// Encode writes img to outPath in the format, at the given quality or the format's configured quality if it is 0.
+// JPEGs carry the metadata the metadata policy allows; the external encoders are given only the ICC profile.
+func (f Format) Encode(img image.Image, outPath string, quality int, meta Metadata) (err error) {
+	if quality <= 0 {
+		quality = f.quality()
+	}
+	if f.Name == JPEG.Name {
+		var buf bytes.Buffer
+		if err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return
+		}
+		return ioutil.WriteFile(outPath, meta.Embed(buf.Bytes()), 0644)
+	}
+
+	// external encoders are handed a lossless intermediate
+	tmp, err := ioutil.TempFile("", "filmstrip-*.png")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err == nil {
+		_, err = tmp.Write(iccPNG(buf.Bytes(), meta.icc))
+	}
+	tmp.Close()
+	if err != nil {
+		return
+	}
+	var args []string
+	for _, arg := range strings.Fields(f.command()) {
+		arg = strings.Replace(arg, "{quality}", strconv.Itoa(quality), -1)
+		arg = strings.Replace(arg, "{in}", tmp.Name(), -1)
+		arg = strings.Replace(arg, "{out}", outPath, -1)
+		args = append(args, arg)
+	}
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		log.Errorf("%s: %s", args[0], strings.TrimSpace(string(out)))
+	}
+	return
+}
+
+func warnOnce(key string, format string, v ...interface{}) {
+	warnedMu.Lock()
+	defer warnedMu.Unlock()
+	if !warned[key] {
+		warned[key] = true
+		log.Warnf(format, v...)
+	}
+}
@@ -0,0 +1,70 @@
+package asset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	tagIPTC      = 0x83bb
+	resourceIPTC = 0x0404 // Photoshop image resource holding IPTC-IIM records
+)
+
+var (
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+	resourceHeader  = []byte("8BIM")
+)
+
+// ReadIPTC returns the IPTC-IIM records of an image: those in the Photoshop APP13 segment of a JPEG, which is only read
+// up to its image data, or the IPTC tag of a TIFF. It returns nil if there are none.
+func ReadIPTC(in io.ReadSeeker) []byte {
+	header := make([]byte, 2)
+	n, _ := io.ReadFull(in, header)
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return nil
+	}
+	if n == 2 && bytes.Equal(header, []byte{0xff, markerSOI}) {
+		return photoshopIPTC(readSegment(in, markerAPP13, photoshopHeader))
+	}
+	if n < 2 || (string(header) != "II" && string(header) != "MM") {
+		return nil
+	}
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil
+	}
+	if _, ifd0, err := parseTIFF(b); err == nil {
+		return ifd0.value(tagIPTC)
+	}
+	return nil
+}
+
+// photoshopIPTC returns the IPTC-IIM records among Photoshop image resources. Each resource is its signature, ID, a
+// padded Pascal string name and the length of its data, followed by the data padded to an even length.
+func photoshopIPTC(resources []byte) []byte {
+	for len(resources) >= 12 && bytes.HasPrefix(resources, resourceHeader) {
+		id := binary.BigEndian.Uint16(resources[4:6])
+		name := 1 + int(resources[6])
+		name += name % 2
+		at := 6 + name
+		if at+4 > len(resources) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(resources[at : at+4]))
+		at += 4
+		if at+size > len(resources) {
+			return nil
+		}
+		if id == resourceIPTC {
+			return resources[at : at+size]
+		}
+		next := at + size + size%2
+		if next > len(resources) {
+			return nil
+		}
+		resources = resources[next:]
+	}
+	return nil
+}
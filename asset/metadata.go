@@ -0,0 +1,299 @@
+package asset
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	METADATA_POLICY    = "metadata.policy"
+	METADATA_STRIP_GPS = "metadata.strip-gps"
+
+	KeepAll       = "keep-all"       // publish all metadata other than GPS, if stripped, and maker notes
+	KeepCopyright = "keep-copyright" // publish only copyright, camera and exposure EXIF fields
+	StripAll      = "strip-all"      // publish no metadata at all
+)
+
+// JPEG markers
+const (
+	markerSOI   = 0xd8
+	markerEOI   = 0xd9
+	markerSOS   = 0xda
+	markerAPP0  = 0xe0
+	markerAPP1  = 0xe1
+	markerAPP2  = 0xe2
+	markerAPP13 = 0xed
+	markerAPP14 = 0xee
+	markerCOM   = 0xfe
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeader  = []byte("ICC_PROFILE\x00")
+	// XMP properties holding GPS data, either as attributes or elements
+	xmpGPS = regexp.MustCompile(`(?s)\s*exif:GPS\w+="[^"]*"|<exif:(GPS\w+)\b[^>]*?(/>|>.*?</exif:GPS\w+>)`)
+)
+
+// structuralTags describe the layout of the image data rather than the photo, and can't be carried from one file to
+// another
+var structuralTags = map[uint16]bool{
+	0x0100: true, 0x0101: true, 0x0102: true, 0x0103: true, 0x0106: true, 0x0111: true, 0x0115: true, 0x0116: true,
+	0x0117: true, 0x011a: true, 0x011b: true, 0x011c: true, 0x0128: true, 0x013d: true, 0x0140: true, 0x014a: true,
+	0x0152: true, 0x0153: true, 0x0201: true, 0x0202: true, 0x02bc: true, 0x83bb: true, 0x8649: true, 0x8773: true,
+}
+
+// exifStructuralTags are the fields of the EXIF IFD describing the image data, PixelXDimension and PixelYDimension,
+// which would report the size of the source rather than that of each rendition
+var exifStructuralTags = map[uint16]bool{0xa002: true, 0xa003: true}
+
+// copyrightTags are the EXIF fields kept by the keep-copyright policy, keyed by the pointer tag of their IFD
+var copyrightTags = map[uint16]map[uint16]bool{
+	0: {0x010f: true, 0x0110: true, 0x013b: true, 0x8298: true, tagExifIFD: true}, // make, model, artist, copyright
+	tagExifIFD: {0x829a: true, 0x829d: true, 0x8822: true, 0x8827: true, 0x9003: true, 0x9011: true, 0x9204: true,
+		0x9209: true, 0x920a: true, 0xa405: true, 0xa433: true, 0xa434: true}, // exposure, dates, focal length and lens
+}
+
+// Metadata is the metadata of a source image, to be carried into its published files according to the metadata policy
+type Metadata struct {
+	exif     []byte // TIFF structure of the EXIF block
+	xmp      []byte
+	iptc     []byte // Photoshop APP13 segment holding IPTC-IIM records
+	comments [][]byte
+	icc      []byte // ICC color profile, which is kept whatever the policy
+}
+
+// jpegSegment is a marker segment of a JPEG, including the marker itself. The SOS segment includes the entropy coded
+// data that follows it.
+type jpegSegment struct {
+	marker byte
+	bytes  []byte
+}
+
+// payload returns the segment data after its marker and length
+func (s jpegSegment) payload() []byte {
+	if len(s.bytes) < 4 {
+		return nil
+	}
+	return s.bytes[4:]
+}
+
+// ReadMetadata reads the metadata of a source image, which for formats other than JPEG is just its EXIF and ICC profile
+func ReadMetadata(source []byte) (m Metadata) {
+	segments, ok := jpegSegments(source)
+	m.icc = readICC(source, segments)
+	if !ok {
+		if r := ExifReader(bytes.NewReader(source)); r != nil {
+			m.exif, _ = ioutil.ReadAll(r)
+		}
+		return
+	}
+	for _, seg := range segments {
+		data := seg.payload()
+		switch {
+		case seg.marker == markerAPP1 && bytes.HasPrefix(data, exifHeader):
+			m.exif = data[len(exifHeader):]
+		case seg.marker == markerAPP1 && bytes.HasPrefix(data, xmpHeader):
+			m.xmp = data[len(xmpHeader):]
+		case seg.marker == markerAPP13:
+			m.iptc = data
+		case seg.marker == markerCOM:
+			m.comments = append(m.comments, data)
+		}
+	}
+	return
+}
+
+// Rewrite returns the source JPEG with its metadata replaced by what the metadata policy allows to be published. Only
+// the JFIF, ICC profile and Adobe segments are kept besides the image data itself, and anything after the image, such
+// as embedded previews, is dropped. If the policy keeps everything the source has, which it never does for a maker note,
+// it is returned unchanged.
+func (m Metadata) Rewrite(source []byte) []byte {
+	if metadataPolicy() == KeepAll && !m.hasMakerNote() && (!stripGPS() || !m.hasGPS()) {
+		return source
+	}
+	segments, ok := jpegSegments(source)
+	if !ok {
+		return source
+	}
+	var out bytes.Buffer
+	out.Write([]byte{0xff, markerSOI})
+	for _, seg := range segments {
+		if seg.marker == markerAPP0 {
+			out.Write(seg.bytes)
+		}
+	}
+	for _, seg := range m.segments() {
+		out.Write(seg)
+	}
+	for _, seg := range segments {
+		switch {
+		case seg.marker == markerSOI || seg.marker == markerAPP0:
+		case seg.marker == markerAPP2 && bytes.HasPrefix(seg.payload(), iccHeader), seg.marker == markerAPP14:
+			out.Write(seg.bytes)
+		case seg.marker >= markerAPP0 && seg.marker <= 0xef, seg.marker == markerCOM:
+		default:
+			out.Write(seg.bytes)
+		}
+	}
+	return out.Bytes()
+}
+
+// Embed returns an encoded JPEG with the metadata the policy allows, and the ICC profile, inserted after its SOI marker
+func (m Metadata) Embed(encoded []byte) []byte {
+	segs := append(m.segments(), iccSegments(m.icc)...)
+	if len(segs) == 0 || !bytes.HasPrefix(encoded, []byte{0xff, markerSOI}) {
+		return encoded
+	}
+	out := append([]byte{}, encoded[:2]...)
+	for _, seg := range segs {
+		out = append(out, seg...)
+	}
+	return append(out, encoded[2:]...)
+}
+
+// segments returns the complete marker segments of the metadata the policy allows to be published, for images whose
+// pixels are already upright
+func (m Metadata) segments() (segs [][]byte) {
+	policy := metadataPolicy()
+	if policy == StripAll {
+		return
+	}
+	if exif := m.publishedExif(policy); exif != nil {
+		segs = appendSegment(segs, markerAPP1, append(append([]byte{}, exifHeader...), exif...))
+	}
+	if policy != KeepAll {
+		return
+	}
+	if len(m.xmp) > 0 {
+		xmp := m.xmp
+		if stripGPS() {
+			xmp = xmpGPS.ReplaceAll(xmp, nil)
+		}
+		segs = appendSegment(segs, markerAPP1, append(append([]byte{}, xmpHeader...), xmp...))
+	}
+	if len(m.iptc) > 0 {
+		segs = appendSegment(segs, markerAPP13, m.iptc)
+	}
+	for _, comment := range m.comments {
+		segs = appendSegment(segs, markerCOM, comment)
+	}
+	return
+}
+
+// publishedExif returns the TIFF structure of the EXIF fields the policy allows, or nil if there are none
+func (m Metadata) publishedExif(policy string) []byte {
+	if len(m.exif) == 0 {
+		return nil
+	}
+	order, ifd0, err := parseTIFF(m.exif)
+	if err != nil {
+		return nil
+	}
+	gps := !stripGPS() && policy == KeepAll
+	ifd := ifd0.filter(func(dir, tag uint16) bool {
+		switch {
+		case dir == 0 && (structuralTags[tag] || tag == tagOrient), dir == tagExifIFD && exifStructuralTags[tag]:
+			return false
+		case tag == tagMakerNote: // maker notes hold offsets that break once moved, and often serial numbers
+			return false
+		case tag == tagGPSIFD:
+			return gps
+		case policy == KeepCopyright:
+			return copyrightTags[dir][tag]
+		}
+		return true
+	})
+	if ifd.empty() {
+		return nil
+	}
+	return writeTIFF(order, ifd)
+}
+
+// hasGPS reports whether the EXIF or XMP metadata includes a location
+func (m Metadata) hasGPS() bool {
+	if xmpGPS.Match(m.xmp) {
+		return true
+	}
+	_, ifd0, err := parseTIFF(m.exif)
+	return err == nil && ifd0.subs[tagGPSIFD] != nil
+}
+
+// hasMakerNote reports whether the EXIF metadata includes a maker note
+func (m Metadata) hasMakerNote() bool {
+	_, ifd0, err := parseTIFF(m.exif)
+	return err == nil && ifd0.subs[tagExifIFD] != nil && ifd0.subs[tagExifIFD].value(tagMakerNote) != nil
+}
+
+// appendSegment appends a marker segment, skipping any too large for a single segment
+func appendSegment(segs [][]byte, marker byte, payload []byte) [][]byte {
+	length := len(payload) + 2
+	if length > 0xffff {
+		return segs
+	}
+	seg := append([]byte{0xff, marker, byte(length >> 8), byte(length)}, payload...)
+	return append(segs, seg)
+}
+
+// jpegSegments splits a JPEG into its marker segments up to and including EOI, reporting false if it isn't a JPEG
+func jpegSegments(b []byte) (segments []jpegSegment, ok bool) {
+	if len(b) < 4 || b[0] != 0xff || b[1] != markerSOI {
+		return nil, false
+	}
+	segments = append(segments, jpegSegment{markerSOI, b[0:2]})
+	i := 2
+	for i+1 < len(b) {
+		if b[i] != 0xff {
+			return nil, false
+		}
+		marker := b[i+1]
+		if marker == 0xff { // fill byte
+			i++
+			continue
+		}
+		if marker == markerEOI {
+			return append(segments, jpegSegment{marker, b[i : i+2]}), true
+		}
+		if i+4 > len(b) {
+			return nil, false
+		}
+		end := i + 2 + int(b[i+2])<<8 + int(b[i+3])
+		if end > len(b) {
+			return nil, false
+		}
+		if marker == markerSOS { // include the entropy coded data, up to the next marker other than a restart
+			for end+1 < len(b) && !(b[end] == 0xff && b[end+1] != 0 && (b[end+1] < 0xd0 || b[end+1] > 0xd7)) {
+				end++
+			}
+			if end+1 >= len(b) {
+				end = len(b)
+			}
+		}
+		segments = append(segments, jpegSegment{marker, b[i:end]})
+		i = end
+	}
+	return segments, true // tolerate a missing EOI
+}
+
+func metadataPolicy() string {
+	switch policy := strings.ToLower(viper.GetString(METADATA_POLICY)); policy {
+	case KeepCopyright, StripAll:
+		return policy
+	}
+	return KeepAll
+}
+
+// stripGPS reports whether GPS metadata is removed from published images, which it is unless disabled
+func stripGPS() bool {
+	return !viper.IsSet(METADATA_STRIP_GPS) || viper.GetBool(METADATA_STRIP_GPS)
+}
+
+// metadataFingerprint describes the metadata config for Fingerprint
+func metadataFingerprint() string {
+	return fmt.Sprintf("metadata=%s strip-gps=%t", metadataPolicy(), stripGPS())
+}
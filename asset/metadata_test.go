@@ -0,0 +1,91 @@
+package asset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// long returns an IFD entry holding a single LONG value
+func long(tag uint16, v uint32) tiffEntry {
+	return tiffEntry{tag: tag, typ: 4, count: 1, value: binary.BigEndian.AppendUint32(nil, v)}
+}
+
+func TestEmbedDropsPixelDimensions(t *testing.T) {
+	exifIFD := &tiffIFD{
+		entries: []tiffEntry{long(0x829a, 1), long(0xa002, 6000), long(0xa003, 4000)},
+		subs:    map[uint16]*tiffIFD{},
+	}
+	ifd0 := &tiffIFD{
+		entries: []tiffEntry{{tag: 0x010f, typ: 2, count: 6, value: []byte("Maker\x00")}, long(0x0100, 6000)},
+		subs:    map[uint16]*tiffIFD{tagExifIFD: exifIFD},
+	}
+	meta := Metadata{exif: writeTIFF(binary.BigEndian, ifd0)}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 60, 40)), nil); err != nil {
+		t.Fatal(err)
+	}
+	rendition := ReadMetadata(meta.Embed(buf.Bytes()))
+	_, got, err := parseTIFF(rendition.exif)
+	if err != nil {
+		t.Fatalf("rendition has no readable EXIF: %v", err)
+	}
+	if got.value(0x010f) == nil {
+		t.Error("rendition lost the camera make")
+	}
+	if got.value(0x0100) != nil {
+		t.Error("rendition kept the source's ImageWidth")
+	}
+	sub := got.subs[tagExifIFD]
+	if sub == nil {
+		t.Fatal("rendition lost the EXIF IFD")
+	}
+	if sub.value(0x829a) == nil {
+		t.Error("rendition lost the exposure time")
+	}
+	for _, tag := range []uint16{0xa002, 0xa003} {
+		if sub.value(tag) != nil {
+			t.Errorf("rendition kept the source's pixel dimension %#04x", tag)
+		}
+	}
+}
+
+// withExif returns a small JPEG carrying the given EXIF metadata as is
+func withExif(t *testing.T, ifd0 *tiffIFD) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 60, 40)), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	app1 := appendSegment(nil, markerAPP1, append(append([]byte{}, exifHeader...), writeTIFF(binary.BigEndian, ifd0)...))
+	return append(append(append([]byte{}, encoded[:2]...), app1[0]...), encoded[2:]...)
+}
+
+func TestRewriteKeepAllDropsMakerNote(t *testing.T) {
+	maker := tiffEntry{tag: 0x010f, typ: 2, count: 6, value: []byte("Maker\x00")}
+	exposure := &tiffIFD{entries: []tiffEntry{long(0x829a, 1)}, subs: map[uint16]*tiffIFD{}}
+	plain := withExif(t, &tiffIFD{entries: []tiffEntry{maker}, subs: map[uint16]*tiffIFD{tagExifIFD: exposure}})
+	if out := ReadMetadata(plain).Rewrite(plain); !bytes.Equal(out, plain) {
+		t.Error("keep-all rewrote a JPEG with nothing to remove")
+	}
+
+	noted := &tiffIFD{
+		entries: []tiffEntry{long(0x829a, 1), {tag: tagMakerNote, typ: 7, count: 8, value: []byte("SERIAL42")}},
+		subs:    map[uint16]*tiffIFD{},
+	}
+	source := withExif(t, &tiffIFD{entries: []tiffEntry{maker}, subs: map[uint16]*tiffIFD{tagExifIFD: noted}})
+	out := ReadMetadata(source).Rewrite(source)
+	if bytes.Contains(out, []byte("SERIAL42")) {
+		t.Error("keep-all published the maker note")
+	}
+	_, ifd0, err := parseTIFF(ReadMetadata(out).exif)
+	if err != nil {
+		t.Fatalf("rewritten JPEG has no readable EXIF: %v", err)
+	}
+	if ifd0.value(0x010f) == nil || ifd0.subs[tagExifIFD] == nil || ifd0.subs[tagExifIFD].value(0x829a) == nil {
+		t.Error("keep-all dropped EXIF fields other than the maker note")
+	}
+}
@@ -0,0 +1,53 @@
+package asset
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Orient returns img transformed for display according to its EXIF orientation, which records how the camera was
+// held rather than the stored pixels being rotated. Orientations 5 through 8 swap the image's width and height.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	src, ok := img.(*image.RGBA)
+	if !ok || src.Bounds().Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(OrientedBounds(src.Bounds(), orientation))
+	dw, dh := dst.Bounds().Dx(), dst.Bounds().Dy()
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotated 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // mirrored horizontally, rotated 90 CCW
+				sx, sy = y, x
+			case 6: // rotated 90 CW
+				sx, sy = y, h-1-x
+			case 7: // mirrored horizontally, rotated 90 CW
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotated 90 CCW
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// OrientedBounds returns the bounds of an image of the given bounds once displayed in the given EXIF orientation
+func OrientedBounds(bounds image.Rectangle, orientation int) image.Rectangle {
+	if orientation >= 5 && orientation <= 8 {
+		return image.Rect(0, 0, bounds.Dy(), bounds.Dx())
+	}
+	return image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+}
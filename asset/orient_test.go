@@ -0,0 +1,62 @@
+package asset
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestOrient turns a 2×3 image whose pixels are labelled
+//
+//	a b
+//	c d
+//	e f
+//
+// upright from each EXIF orientation
+func TestOrient(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 3))
+	for i, label := range "abcdef" {
+		src.Set(i%2, i/2, color.RGBA{R: uint8(label), A: 255})
+	}
+	tests := []struct {
+		orientation int
+		want        []string // rows of the result
+	}{
+		{0, []string{"ab", "cd", "ef"}},
+		{1, []string{"ab", "cd", "ef"}},
+		{2, []string{"ba", "dc", "fe"}},
+		{3, []string{"fe", "dc", "ba"}},
+		{4, []string{"ef", "cd", "ab"}},
+		{5, []string{"ace", "bdf"}},
+		{6, []string{"eca", "fdb"}},
+		{7, []string{"fdb", "eca"}},
+		{8, []string{"bdf", "ace"}},
+		{9, []string{"ab", "cd", "ef"}},
+	}
+	for _, test := range tests {
+		img := Orient(src, test.orientation)
+		bounds := img.Bounds()
+		if want := OrientedBounds(src.Bounds(), test.orientation); bounds != want {
+			t.Errorf("orientation %d: bounds %v, OrientedBounds says %v", test.orientation, bounds, want)
+		}
+		var rows []string
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			var row []byte
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, _, _, _ := img.At(x, y).RGBA()
+				row = append(row, byte(r>>8))
+			}
+			rows = append(rows, string(row))
+		}
+		if len(rows) != len(test.want) {
+			t.Errorf("orientation %d: got rows %q, want %q", test.orientation, rows, test.want)
+			continue
+		}
+		for i := range rows {
+			if rows[i] != test.want[i] {
+				t.Errorf("orientation %d: got rows %q, want %q", test.orientation, rows, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package asset
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	"github.com/nfnt/resize"
+)
+
+const (
+	placeholderWidth   = 16 // width of the inline placeholder image, which browsers scale up and blur
+	placeholderQuality = 50
+	colorBits          = 4 // bits of each channel used to bucket colors when finding the dominant one
+)
+
+// Preview is what is shown in place of an image while it loads: a tiny inline copy of it, and its dominant color
+type Preview struct {
+	Placeholder string `json:"placeholder"` // data URI of a tiny JPEG of the image
+	Color       string `json:"color"`       // dominant color as a CSS hex color
+}
+
+// NewPreview makes the preview of an upright image
+func NewPreview(img image.Image) (p Preview) {
+	if img.Bounds().Empty() {
+		return
+	}
+	tiny := resize.Resize(placeholderWidth, 0, img, resize.Bilinear)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, tiny, &jpeg.Options{Quality: placeholderQuality}); err == nil {
+		p.Placeholder = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+	p.Color = dominantColor(tiny)
+	return
+}
+
+// dominantColor returns the average color of the most common bucket of similar colors in img
+func dominantColor(img image.Image) string {
+	type bucket struct{ n, r, g, b uint32 }
+	var (
+		buckets = map[uint32]*bucket{}
+		top     *bucket
+		bounds  = img.Bounds()
+		shift   = uint(16 - colorBits)
+	)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			key := r>>shift<<(2*colorBits) | g>>shift<<colorBits | b>>shift
+			bk := buckets[key]
+			if bk == nil {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.n++
+			bk.r += r >> 8
+			bk.g += g >> 8
+			bk.b += b >> 8
+			if top == nil || bk.n > top.n {
+				top = bk
+			}
+		}
+	}
+	if top == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", top.r/top.n, top.g/top.n, top.b/top.n)
+}
@@ -0,0 +1,160 @@
+package asset
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+	"github.com/spf13/viper"
+)
+
+const (
+	RENDITIONS_WIDTHS    = "renditions.widths"
+	RENDITIONS_LONG_EDGE = "renditions.max-long-edge"
+	RENDITIONS_STEPS     = "renditions.steps"
+	RENDITIONS_UPSCALE   = "renditions.upscale"
+	RENDITIONS_QUALITY   = "renditions.quality"
+	RENDITIONS_FILTER    = "renditions.filter"
+	RENDITIONS_FILTERS   = "renditions.filters"
+	RENDITIONS_SHARPEN   = "renditions.sharpen"
+)
+
+// Filters are the resampling filters that may be configured for renditions
+var Filters = map[string]resize.InterpolationFunction{
+	"nearest":  resize.NearestNeighbor,
+	"bilinear": resize.Bilinear,
+	"bicubic":  resize.Bicubic,
+	"mitchell": resize.MitchellNetravali,
+	"lanczos2": resize.Lanczos2,
+	"lanczos3": resize.Lanczos3,
+}
+
+// DefaultFilter is the resampling filter used when none is configured
+const DefaultFilter = "lanczos3"
+
+// DefaultWidths is the rendition ladder used when none is configured, lined up with common viewport breakpoints
+var DefaultWidths = []int{320, 640, 960, 1280, 1920, 2560}
+
+// Rendition is one step of the ladder of sizes each image is cut to
+type Rendition struct {
+	Width   int     // width of the rendition in pixels
+	Quality int     // JPEG quality of the rendition, or 0 to use jpeg-quality
+	Filter  string  // resampling filter used to resize the image
+	Sharpen Sharpen // unsharp mask applied once resized, if any
+}
+
+// Ladder returns the renditions to cut from an upright image of the given bounds, largest first. Renditions are either
+// the configured renditions.widths, or if renditions.max-long-edge is set, renditions.steps evenly spaced long edges up
+// to it. Renditions that would be as wide as the image itself, which is always published too, are skipped, as are
+// wider ones unless renditions.upscale is set.
+func Ladder(bounds image.Rectangle) (ladder []Rendition) {
+	var (
+		w, h    = bounds.Dx(), bounds.Dy()
+		upscale = viper.GetBool(RENDITIONS_UPSCALE)
+		seen    = map[int]bool{}
+	)
+	if w <= 0 || h <= 0 {
+		return
+	}
+	for _, target := range ladderTargets() {
+		width := target
+		if viper.GetInt(RENDITIONS_LONG_EDGE) > 0 && h > w { // target is the long edge, which is the height
+			width = int(math.Round(float64(target) * float64(w) / float64(h)))
+		}
+		if width <= 0 || width == w || (width > w && !upscale) || seen[width] {
+			continue
+		}
+		seen[width] = true
+		ladder = append(ladder, Rendition{Width: width, Quality: renditionQuality(target), Filter: renditionFilter(target),
+			Sharpen: renditionSharpen(target)})
+	}
+	sort.Slice(ladder, func(i, j int) bool { return ladder[i].Width > ladder[j].Width })
+	return
+}
+
+// Height returns the height of the rendition of an image of the given bounds
+func (r Rendition) Height(bounds image.Rectangle) int {
+	return int(math.Round(float64(bounds.Dy()) * float64(r.Width) / float64(bounds.Dx())))
+}
+
+// Cut resizes the upright image img to the rendition with its filter, then sharpens it
+func (r Rendition) Cut(img image.Image) image.Image {
+	bounds := img.Bounds()
+	resized := resize.Resize(uint(r.Width), uint(r.Height(bounds)), img, Filters[r.Filter])
+	return r.Sharpen.Apply(resized)
+}
+
+// Suffix returns the suffix added to the image's name for the rendition
+func (r Rendition) Suffix() string {
+	return fmt.Sprintf("_%dw", r.Width)
+}
+
+// ladderTargets returns the configured target widths, or long edges if renditions.max-long-edge is set
+func ladderTargets() (targets []int) {
+	if longEdge := viper.GetInt(RENDITIONS_LONG_EDGE); longEdge > 0 {
+		steps := viper.GetInt(RENDITIONS_STEPS)
+		if steps <= 0 {
+			steps = 1
+		}
+		for i := steps; i > 0; i-- {
+			targets = append(targets, longEdge*i/steps)
+		}
+		return
+	}
+	for _, width := range viper.GetStringSlice(RENDITIONS_WIDTHS) {
+		if w, err := strconv.Atoi(width); err == nil && w > 0 {
+			targets = append(targets, w)
+		}
+	}
+	if len(targets) == 0 {
+		targets = DefaultWidths
+	}
+	return
+}
+
+// renditionQuality returns the JPEG quality configured in renditions.quality for the given target, or 0 if there isn't one
+func renditionQuality(target int) int {
+	q, _ := strconv.Atoi(fmt.Sprint(viper.GetStringMap(RENDITIONS_QUALITY)[strconv.Itoa(target)]))
+	return q
+}
+
+// renditionFilter returns the resampling filter configured in renditions.filters for the given target, otherwise
+// renditions.filter, or the default filter if neither names a known filter
+func renditionFilter(target int) string {
+	for _, name := range []string{fmt.Sprint(viper.GetStringMap(RENDITIONS_FILTERS)[strconv.Itoa(target)]), viper.GetString(RENDITIONS_FILTER)} {
+		name = strings.ToLower(name)
+		if _, ok := Filters[name]; ok {
+			return name
+		} else if name != "" && name != "<nil>" {
+			warnOnce("filter-"+name, "ignoring unknown resampling filter %s", name)
+		}
+	}
+	return DefaultFilter
+}
+
+// renditionSharpen returns the unsharp mask configured in renditions.sharpen for the given target, if any
+func renditionSharpen(target int) (sharpen Sharpen) {
+	key := RENDITIONS_SHARPEN + "." + strconv.Itoa(target)
+	if !viper.IsSet(key) {
+		return
+	}
+	sharpen.Amount = viper.GetFloat64(key + ".amount")
+	sharpen.Radius = viper.GetFloat64(key + ".radius")
+	sharpen.Threshold = viper.GetFloat64(key + ".threshold")
+	return
+}
+
+// ladderFingerprint describes the ladder config for Fingerprint
+func ladderFingerprint() string {
+	var filters, sharpens []string
+	for _, target := range ladderTargets() {
+		filters = append(filters, renditionFilter(target))
+		sharpens = append(sharpens, renditionSharpen(target).String())
+	}
+	return fmt.Sprintf("ladder=%v long-edge=%t upscale=%t quality=%v filters=%v sharpen=%v", ladderTargets(),
+		viper.GetInt(RENDITIONS_LONG_EDGE) > 0, viper.GetBool(RENDITIONS_UPSCALE), viper.GetStringMap(RENDITIONS_QUALITY), filters, sharpens)
+}
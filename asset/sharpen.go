@@ -0,0 +1,113 @@
+package asset
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// defaultSharpenRadius is the blur radius of an unsharp mask that doesn't configure one
+const defaultSharpenRadius = 0.5
+
+// Sharpen is an unsharp mask, which adds back the difference between an image and a blurred copy of it
+type Sharpen struct {
+	Amount    float64 // strength of the mask, e.g. 0.5 for 50%; no sharpening if 0
+	Radius    float64 // standard deviation of the blur in pixels
+	Threshold float64 // smallest difference from the blur, out of 255, that is sharpened
+}
+
+// String describes the mask for Fingerprint
+func (s Sharpen) String() string {
+	if s.Amount <= 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%v/%v/%v", s.Amount, s.radius(), s.Threshold)
+}
+
+// Apply returns a sharpened copy of img, or img itself if the mask has no amount
+func (s Sharpen) Apply(img image.Image) image.Image {
+	if s.Amount <= 0 {
+		return img
+	}
+	bounds := img.Bounds()
+	src := image.NewRGBA(bounds)
+	draw.Draw(src, bounds, img, bounds.Min, draw.Src)
+	blurred := blur(src, s.radius())
+	out := image.NewRGBA(bounds)
+	for i := range src.Pix {
+		if i%4 == 3 { // leave alpha alone
+			out.Pix[i] = src.Pix[i]
+			continue
+		}
+		diff := float64(src.Pix[i]) - blurred[i]
+		if math.Abs(diff) < s.Threshold {
+			out.Pix[i] = src.Pix[i]
+			continue
+		}
+		out.Pix[i] = clamp(float64(src.Pix[i]) + s.Amount*diff)
+	}
+	return out
+}
+
+// radius returns the blur radius, or the default if none is configured
+func (s Sharpen) radius() float64 {
+	if s.Radius > 0 {
+		return s.Radius
+	}
+	return defaultSharpenRadius
+}
+
+// blur returns the channels of img, in the same layout as its Pix, after a gaussian blur with standard deviation sigma
+func blur(img *image.RGBA, sigma float64) []float64 {
+	var (
+		w, h   = img.Rect.Dx(), img.Rect.Dy()
+		kernel = gaussian(sigma)
+		reach  = len(kernel) / 2
+		tmp    = make([]float64, len(img.Pix))
+		out    = make([]float64, len(img.Pix))
+	)
+	// the blur is separable, so blur each row and then each column of the result
+	pass := func(in func(i int) float64, res []float64, step func(x, y int) int, across, along int) {
+		for a := 0; a < across; a++ {
+			for b := 0; b < along; b++ {
+				for c := 0; c < 3; c++ {
+					var sum float64
+					for k, weight := range kernel {
+						at := b + k - reach
+						if at < 0 {
+							at = 0
+						} else if at >= along {
+							at = along - 1
+						}
+						sum += weight * in(step(a, at)+c)
+					}
+					res[step(a, b)+c] = sum
+				}
+			}
+		}
+	}
+	pass(func(i int) float64 { return float64(img.Pix[i]) }, tmp, func(y, x int) int { return y*img.Stride + x*4 }, h, w)
+	pass(func(i int) float64 { return tmp[i] }, out, func(x, y int) int { return y*img.Stride + x*4 }, w, h)
+	return out
+}
+
+// gaussian returns a normalized gaussian kernel with standard deviation sigma, reaching out three deviations
+func gaussian(sigma float64) (kernel []float64) {
+	reach := int(math.Ceil(sigma * 3))
+	var sum float64
+	for i := -reach; i <= reach; i++ {
+		weight := math.Exp(-float64(i*i) / (2 * sigma * sigma))
+		kernel = append(kernel, weight)
+		sum += weight
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+	return
+}
+
+// clamp rounds v to the nearest 8 bit channel value
+func clamp(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
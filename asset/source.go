@@ -0,0 +1,105 @@
+package asset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+
+	// decoders for the source image formats filmstrip accepts out of the box; importing any other package that
+	// registers itself with image.RegisterFormat adds support for its format
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// maxChunk is the largest metadata chunk read from a source image, beyond which its length is taken to be corrupt
+const maxChunk = 64 << 20
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	riffHeader   = []byte("RIFF")
+)
+
+// IsImage reports whether the file at path is in a registered image format
+func IsImage(path string) bool {
+	in, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer in.Close()
+	_, _, err = image.DecodeConfig(in)
+	return err == nil
+}
+
+// ExifReader returns a reader of the EXIF metadata of an image for exif.Decode, which itself only understands JPEG and
+// TIFF files. For PNG and WebP images the EXIF chunk is extracted, and nil is returned if there isn't one.
+func ExifReader(in io.ReadSeeker) io.Reader {
+	header := make([]byte, 12)
+	n, _ := io.ReadFull(in, header)
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return nil
+	}
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, pngSignature):
+		return chunk(in, len(pngSignature), "eXIf", binary.BigEndian, false)
+	case bytes.HasPrefix(header, riffHeader) && n == 12 && string(header[8:12]) == "WEBP":
+		return chunk(in, 12, "EXIF", binary.LittleEndian, true)
+	}
+	return in
+}
+
+// chunk scans a PNG or RIFF style sequence of chunks starting at offset for the one named name, returning its data.
+// PNG chunks are length then type, with a trailing CRC; RIFF chunks are type then length, padded to an even length.
+func chunk(in io.Reader, offset int, name string, order binary.ByteOrder, riff bool) io.Reader {
+	if _, err := io.CopyN(ioutil.Discard, in, int64(offset)); err != nil {
+		return nil
+	}
+	head := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(in, head); err != nil {
+			return nil
+		}
+		length, kind := order.Uint32(head[0:4]), string(head[4:8])
+		if riff {
+			kind, length = string(head[0:4]), order.Uint32(head[4:8])
+		}
+		if kind == name {
+			data, err := readChunk(in, length)
+			if err != nil {
+				return nil
+			}
+			// WebP EXIF chunks sometimes keep the JPEG APP1 identifier
+			return bytes.NewReader(bytes.TrimPrefix(data, []byte("Exif\x00\x00")))
+		}
+		skip := int64(length)
+		if riff {
+			skip += int64(length % 2)
+		} else {
+			skip += 4 // CRC
+		}
+		if _, err := io.CopyN(ioutil.Discard, in, skip); err != nil {
+			return nil
+		}
+	}
+}
+
+// readChunk reads the length bytes of a chunk's data, returning an error rather than allocating them if the length is
+// larger than maxChunk or than what is left to read
+func readChunk(in io.Reader, length uint32) ([]byte, error) {
+	if length > maxChunk {
+		return nil, fmt.Errorf("chunk of %d bytes is too large", length)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(in, int64(length)))
+	if err == nil && len(data) < int(length) {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
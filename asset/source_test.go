@@ -0,0 +1,62 @@
+package asset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// webpFile returns a WebP file made of a single chunk, with the given length recorded in its header
+func webpFile(kind string, length uint32, data []byte) []byte {
+	b := append([]byte("RIFF\x00\x00\x00\x00WEBP"), kind...)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	return append(b, data...)
+}
+
+// pngFile returns a PNG file made of a single chunk, with the given length recorded in its header
+func pngFile(kind string, length uint32, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(append([]byte{}, pngSignature...), length)
+	b = append(append(b, kind...), data...)
+	return append(b, 0, 0, 0, 0) // CRC
+}
+
+func TestChunk(t *testing.T) {
+	exif := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	app1 := append([]byte("Exif\x00\x00"), exif...)
+	tests := []struct {
+		name   string
+		source []byte
+		want   []byte
+	}{
+		{"webp", webpFile("EXIF", uint32(len(exif)), exif), exif},
+		{"webp with APP1 identifier", webpFile("EXIF", uint32(len(app1)), app1), exif},
+		{"png", pngFile("eXIf", uint32(len(exif)), exif), exif},
+		{"webp claiming 4 GiB", webpFile("EXIF", 0xffffffff, exif), nil},
+		{"png claiming 4 GiB", pngFile("eXIf", 0xffffffff, exif), nil},
+		{"webp claiming more than is left", webpFile("EXIF", 1<<20, exif), nil},
+		{"png claiming more than is left", pngFile("eXIf", 1<<20, exif), nil},
+	}
+	for _, test := range tests {
+		r := ExifReader(bytes.NewReader(test.source))
+		if test.want == nil {
+			if r != nil {
+				t.Errorf("%s: read a chunk from a corrupt file", test.name)
+			}
+			continue
+		}
+		if r == nil {
+			t.Errorf("%s: no chunk read", test.name)
+			continue
+		}
+		if got, _ := ioutil.ReadAll(r); !bytes.Equal(got, test.want) {
+			t.Errorf("%s: read %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPNGXMPCorrupt(t *testing.T) {
+	if xmp := ReadXMP(bytes.NewReader(pngFile("iTXt", 0xfffffffc, []byte("XML:com.adobe.xmp")))); xmp != nil {
+		t.Errorf("read XMP %q from a corrupt PNG", xmp)
+	}
+}
@@ -0,0 +1,191 @@
+package asset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// TIFF tags that point to sub-IFDs, and the tags of interest within them
+const (
+	tagExifIFD    = 0x8769
+	tagGPSIFD     = 0x8825
+	tagInteropIFD = 0xa005
+	tagMakerNote  = 0x927c
+	tagOrient     = 0x0112
+)
+
+var errTIFF = errors.New("malformed TIFF metadata")
+
+// tiffTypeSizes are the byte sizes of the TIFF field types
+var tiffTypeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// tiffEntry is a single IFD field, with its value bytes in the byte order of the TIFF it was read from
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// tiffIFD is an image file directory, along with the sub-IFDs its pointer tags refer to
+type tiffIFD struct {
+	entries []tiffEntry
+	subs    map[uint16]*tiffIFD
+}
+
+// parseTIFF reads IFD0 of EXIF metadata, along with its EXIF, GPS and interoperability sub-IFDs. Thumbnail IFDs are
+// not read, since they are never carried into published images.
+func parseTIFF(b []byte) (order binary.ByteOrder, ifd0 *tiffIFD, err error) {
+	if len(b) < 8 {
+		return nil, nil, errTIFF
+	}
+	switch string(b[0:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, nil, errTIFF
+	}
+	ifd0, err = readIFD(b, order, order.Uint32(b[4:8]), 0)
+	return
+}
+
+func readIFD(b []byte, order binary.ByteOrder, offset uint32, depth int) (ifd *tiffIFD, err error) {
+	if depth > 2 || uint64(offset)+2 > uint64(len(b)) {
+		return nil, errTIFF
+	}
+	n := uint32(order.Uint16(b[offset:]))
+	if uint64(offset)+2+uint64(n)*12 > uint64(len(b)) {
+		return nil, errTIFF
+	}
+	ifd = &tiffIFD{subs: map[uint16]*tiffIFD{}}
+	for i := uint32(0); i < n; i++ {
+		e := b[offset+2+i*12:]
+		entry := tiffEntry{tag: order.Uint16(e[0:2]), typ: order.Uint16(e[2:4]), count: order.Uint32(e[4:8])}
+		size, ok := tiffTypeSizes[entry.typ]
+		if !ok {
+			continue
+		}
+		length := uint64(size) * uint64(entry.count)
+		if length <= 4 {
+			entry.value = append([]byte{}, e[8:8+length]...)
+		} else {
+			at := uint64(order.Uint32(e[8:12]))
+			if at+length > uint64(len(b)) {
+				continue // skip fields pointing outside the metadata rather than giving up on all of it
+			}
+			entry.value = append([]byte{}, b[at:at+length]...)
+		}
+		switch entry.tag {
+		case tagExifIFD, tagGPSIFD, tagInteropIFD:
+			if sub, err := readIFD(b, order, order.Uint32(e[8:12]), depth+1); err == nil {
+				ifd.subs[entry.tag] = sub
+			}
+			continue
+		}
+		ifd.entries = append(ifd.entries, entry)
+	}
+	return
+}
+
+// value returns the value bytes of the IFD's entry for tag, or nil if it has none
+func (ifd *tiffIFD) value(tag uint16) []byte {
+	for _, entry := range ifd.entries {
+		if entry.tag == tag {
+			return entry.value
+		}
+	}
+	return nil
+}
+
+// filter returns a copy of the IFD and its sub-IFDs with only the entries for which keep returns true. Sub-IFDs are
+// identified to keep by the tag that points to them, or 0 for IFD0.
+func (ifd *tiffIFD) filter(keep func(dir, tag uint16) bool) *tiffIFD {
+	return ifd.filterDir(0, keep)
+}
+
+func (ifd *tiffIFD) filterDir(dir uint16, keep func(dir, tag uint16) bool) *tiffIFD {
+	out := &tiffIFD{subs: map[uint16]*tiffIFD{}}
+	for _, entry := range ifd.entries {
+		if keep(dir, entry.tag) {
+			out.entries = append(out.entries, entry)
+		}
+	}
+	for tag, sub := range ifd.subs {
+		if !keep(dir, tag) {
+			continue
+		}
+		if filtered := sub.filterDir(tag, keep); !filtered.empty() {
+			out.subs[tag] = filtered
+		}
+	}
+	return out
+}
+
+func (ifd *tiffIFD) empty() bool {
+	return len(ifd.entries) == 0 && len(ifd.subs) == 0
+}
+
+// writeTIFF lays out ifd0 and its sub-IFDs as a complete TIFF structure in the given byte order
+func writeTIFF(order binary.ByteOrder, ifd0 *tiffIFD) []byte {
+	var buf bytes.Buffer
+	if order == binary.LittleEndian {
+		buf.WriteString("II*\x00")
+	} else {
+		buf.WriteString("MM\x00*")
+	}
+	binary.Write(&buf, order, uint32(8))
+	buf.Write(ifd0.layout(order, 8))
+	return buf.Bytes()
+}
+
+// layout returns the bytes of the IFD placed at offset: its entries, then the values too large to fit in them, then
+// each of its sub-IFDs
+func (ifd *tiffIFD) layout(order binary.ByteOrder, offset uint32) []byte {
+	entries := append([]tiffEntry{}, ifd.entries...)
+	var subTags []uint16
+	for tag := range ifd.subs {
+		subTags = append(subTags, tag)
+		entries = append(entries, tiffEntry{tag: tag, typ: 4, count: 1, value: make([]byte, 4)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+	sort.Slice(subTags, func(i, j int) bool { return subTags[i] < subTags[j] })
+
+	var (
+		head, data bytes.Buffer
+		dataAt     = offset + 2 + uint32(len(entries))*12 + 4
+		pointers   = map[uint16]int{} // position of each sub-IFD pointer within head
+	)
+	binary.Write(&head, order, uint16(len(entries)))
+	for _, entry := range entries {
+		binary.Write(&head, order, entry.tag)
+		binary.Write(&head, order, entry.typ)
+		binary.Write(&head, order, entry.count)
+		if _, ok := ifd.subs[entry.tag]; ok {
+			pointers[entry.tag] = head.Len()
+		}
+		if len(entry.value) <= 4 {
+			value := make([]byte, 4)
+			copy(value, entry.value)
+			head.Write(value)
+			continue
+		}
+		binary.Write(&head, order, dataAt+uint32(data.Len()))
+		data.Write(entry.value)
+		if data.Len()%2 == 1 {
+			data.WriteByte(0) // values start on word boundaries
+		}
+	}
+	binary.Write(&head, order, uint32(0)) // no next IFD
+
+	out := append(head.Bytes(), data.Bytes()...)
+	for _, tag := range subTags {
+		at := offset + uint32(len(out))
+		order.PutUint32(out[pointers[tag]:], at)
+		out = append(out, ifd.subs[tag].layout(order, at)...)
+	}
+	return out
+}
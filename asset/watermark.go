@@ -0,0 +1,199 @@
+package asset
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/gpitfield/relog"
+	"github.com/nfnt/resize"
+	"github.com/spf13/viper"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	WATERMARK_IMAGE     = "watermark.image"
+	WATERMARK_TEXT      = "watermark.text"
+	WATERMARK_POSITION  = "watermark.position"
+	WATERMARK_OPACITY   = "watermark.opacity"
+	WATERMARK_MIN_WIDTH = "watermark.min-width"
+	WATERMARK_SCALE     = "watermark.scale"
+	WATERMARK_SKIP      = "watermark.skip"
+)
+
+const (
+	defaultWatermarkOpacity  = 0.5
+	defaultWatermarkMinWidth = 1280
+	defaultWatermarkScale    = 0.2 // watermark width as a fraction of the image width
+	watermarkMargin          = 0.02
+)
+
+var (
+	overlays      = map[string]image.Image{} // decoded watermark images by path
+	overlaysMu    sync.Mutex
+	fontOnce      sync.Once
+	watermarkFont *opentype.Font
+)
+
+// Watermarking reports whether a watermark image or text is configured
+func Watermarking() bool {
+	return viper.GetString(WATERMARK_IMAGE) != "" || viper.GetString(WATERMARK_TEXT) != ""
+}
+
+// watermarks reports whether an image of the given width is large enough to be watermarked
+func watermarks(width int) bool {
+	return Watermarking() && width >= watermarkMinWidth()
+}
+
+// watermark returns a copy of img with the configured watermark composited over it, or img itself if it is too small
+// to be watermarked or the watermark can't be drawn
+func watermark(img image.Image) image.Image {
+	bounds := img.Bounds()
+	if !watermarks(bounds.Dx()) {
+		return img
+	}
+	mark, err := overlay(int(math.Round(float64(bounds.Dx()) * watermarkScale())))
+	if err != nil {
+		warnOnce("watermark", "not watermarking images: %s", err)
+		return img
+	}
+	out := image.NewRGBA(bounds)
+	draw.Draw(out, bounds, img, bounds.Min, draw.Src)
+	at := watermarkPosition(bounds, mark.Bounds())
+	opacity := image.NewUniform(color.Alpha{uint8(math.Round(watermarkOpacity() * 255))})
+	draw.DrawMask(out, mark.Bounds().Sub(mark.Bounds().Min).Add(at), mark, mark.Bounds().Min, opacity, image.ZP, draw.Over)
+	return out
+}
+
+// overlay returns the watermark scaled to the given width, either the configured image or the configured text
+func overlay(width int) (image.Image, error) {
+	if width <= 0 {
+		return nil, fmt.Errorf("watermark scale %v is too small", watermarkScale())
+	}
+	path := viper.GetString(WATERMARK_IMAGE)
+	if path == "" {
+		return textOverlay(viper.GetString(WATERMARK_TEXT), width)
+	}
+	overlaysMu.Lock()
+	mark, ok := overlays[path]
+	if !ok {
+		in, err := os.Open(path)
+		if err != nil {
+			overlaysMu.Unlock()
+			return nil, err
+		}
+		mark, _, err = image.Decode(in)
+		in.Close()
+		if err != nil {
+			overlaysMu.Unlock()
+			return nil, err
+		}
+		overlays[path] = mark
+	}
+	overlaysMu.Unlock()
+	return resize.Resize(uint(width), 0, mark, resize.Lanczos3), nil
+}
+
+// textOverlay renders text in white with the bundled Go Bold font, sized so that it is the given width
+func textOverlay(text string, width int) (image.Image, error) {
+	var err error
+	fontOnce.Do(func() {
+		watermarkFont, err = opentype.Parse(gobold.TTF)
+	})
+	if watermarkFont == nil {
+		return nil, fmt.Errorf("could not load watermark font: %v", err)
+	}
+	// measure the text at a nominal size, then scale the font to the width wanted
+	const nominal = 100
+	face, err := opentype.NewFace(watermarkFont, &opentype.FaceOptions{Size: nominal, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, err
+	}
+	advance := font.MeasureString(face, text).Ceil()
+	face.Close()
+	if advance <= 0 {
+		return nil, fmt.Errorf("watermark text is empty")
+	}
+	face, err = opentype.NewFace(watermarkFont, &opentype.FaceOptions{Size: nominal * float64(width) / float64(advance), DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+	metrics := face.Metrics()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+	mark := image.NewRGBA(image.Rect(0, 0, font.MeasureString(face, text).Ceil(), height))
+	drawer := font.Drawer{Dst: mark, Src: image.White, Face: face, Dot: fixed.Point26_6{Y: metrics.Ascent}}
+	drawer.DrawString(text)
+	return mark, nil
+}
+
+// watermarkPosition returns where to draw a watermark of the given bounds over an image of the given bounds
+func watermarkPosition(img, mark image.Rectangle) image.Point {
+	margin := int(math.Round(float64(img.Dx()) * watermarkMargin))
+	left, top := img.Min.X+margin, img.Min.Y+margin
+	right, bottom := img.Max.X-margin-mark.Dx(), img.Max.Y-margin-mark.Dy()
+	switch position := strings.ToLower(viper.GetString(WATERMARK_POSITION)); position {
+	case "top-left":
+		return image.Pt(left, top)
+	case "top-right":
+		return image.Pt(right, top)
+	case "bottom-left":
+		return image.Pt(left, bottom)
+	case "center":
+		return image.Pt(img.Min.X+(img.Dx()-mark.Dx())/2, img.Min.Y+(img.Dy()-mark.Dy())/2)
+	case "", "bottom-right":
+	default:
+		warnOnce("watermark-position", "unknown watermark position %s, using bottom-right", position)
+	}
+	return image.Pt(right, bottom)
+}
+
+// watermarkOpacity returns the configured opacity of the watermark, between 0 and 1
+func watermarkOpacity() float64 {
+	if !viper.IsSet(WATERMARK_OPACITY) {
+		return defaultWatermarkOpacity
+	}
+	return math.Max(0, math.Min(1, viper.GetFloat64(WATERMARK_OPACITY)))
+}
+
+// watermarkMinWidth returns the narrowest image width that is watermarked
+func watermarkMinWidth() int {
+	if !viper.IsSet(WATERMARK_MIN_WIDTH) {
+		return defaultWatermarkMinWidth
+	}
+	return viper.GetInt(WATERMARK_MIN_WIDTH)
+}
+
+// watermarkScale returns the width of the watermark as a fraction of the width of the image
+func watermarkScale() float64 {
+	if scale := viper.GetFloat64(WATERMARK_SCALE); scale > 0 {
+		return math.Min(scale, 1)
+	}
+	return defaultWatermarkScale
+}
+
+// watermarkFingerprint describes the watermark config for Fingerprint, including the content of the watermark image
+func watermarkFingerprint() string {
+	if !Watermarking() {
+		return ""
+	}
+	mark := viper.GetString(WATERMARK_TEXT)
+	if path := viper.GetString(WATERMARK_IMAGE); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Error(err)
+		}
+		mark = Hash(b)
+	}
+	return fmt.Sprintf(" watermark=%q position=%s opacity=%v min-width=%d scale=%v", mark,
+		strings.ToLower(viper.GetString(WATERMARK_POSITION)), watermarkOpacity(), watermarkMinWidth(), watermarkScale())
+}
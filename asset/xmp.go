@@ -0,0 +1,112 @@
+package asset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+const tagXMP = 0x02bc
+
+var xmpKeyword = []byte("XML:com.adobe.xmp\x00")
+
+// ReadXMP returns the XMP packet embedded in an image: the APP1 segment of a JPEG, the iTXt chunk of a PNG, the XMP
+// chunk of a WebP or the XMP tag of a TIFF. JPEGs are only read up to their image data. It returns nil if there isn't
+// a packet.
+func ReadXMP(in io.ReadSeeker) []byte {
+	header := make([]byte, 12)
+	n, _ := io.ReadFull(in, header)
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return nil
+	}
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, []byte{0xff, markerSOI}):
+		return readSegment(in, markerAPP1, xmpHeader)
+	case bytes.HasPrefix(header, pngSignature):
+		return pngXMP(in)
+	case bytes.HasPrefix(header, riffHeader) && n == 12 && string(header[8:12]) == "WEBP":
+		if r := chunk(in, 12, "XMP ", binary.LittleEndian, true); r != nil {
+			xmp, _ := ioutil.ReadAll(r)
+			return xmp
+		}
+		return nil
+	}
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil
+	}
+	if _, ifd0, err := parseTIFF(b); err == nil {
+		return ifd0.value(tagXMP)
+	}
+	return nil
+}
+
+// readSegment reads the marker segments of a JPEG up to its image data, returning the payload after header of the first
+// segment with the given marker whose payload starts with header
+func readSegment(in io.Reader, want byte, header []byte) []byte {
+	if _, err := io.CopyN(ioutil.Discard, in, 2); err != nil {
+		return nil
+	}
+	head := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(in, head); err != nil || head[0] != 0xff {
+			return nil
+		}
+		marker, length := head[1], int(binary.BigEndian.Uint16(head[2:4]))
+		if marker == markerSOS || marker == markerEOI || length < 2 {
+			return nil
+		}
+		payload := make([]byte, length-2)
+		if _, err := io.ReadFull(in, payload); err != nil {
+			return nil
+		}
+		if marker == want && bytes.HasPrefix(payload, header) {
+			return payload[len(header):]
+		}
+	}
+}
+
+// pngXMP scans the chunks of a PNG for the uncompressed iTXt chunk holding XMP
+func pngXMP(in io.Reader) []byte {
+	if _, err := io.CopyN(ioutil.Discard, in, int64(len(pngSignature))); err != nil {
+		return nil
+	}
+	head := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(in, head); err != nil {
+			return nil
+		}
+		length, kind := binary.BigEndian.Uint32(head[0:4]), string(head[4:8])
+		if kind == "IEND" {
+			return nil
+		}
+		if kind != "iTXt" {
+			if _, err := io.CopyN(ioutil.Discard, in, int64(length)+4); err != nil {
+				return nil
+			}
+			continue
+		}
+		data, err := readChunk(in, length)
+		if err != nil {
+			return nil
+		}
+		if _, err = io.CopyN(ioutil.Discard, in, 4); err != nil { // CRC
+			return nil
+		}
+		if !bytes.HasPrefix(data, xmpKeyword) || len(data) < len(xmpKeyword)+2 || data[len(xmpKeyword)] != 0 {
+			continue // not XMP, or compressed
+		}
+		// skip the compression flag and method, then the language tag and translated keyword
+		rest := data[len(xmpKeyword)+2:]
+		for i := 0; i < 2; i++ {
+			end := bytes.IndexByte(rest, 0)
+			if end < 0 {
+				return nil
+			}
+			rest = rest[end+1:]
+		}
+		return rest
+	}
+}
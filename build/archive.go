@@ -0,0 +1,113 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/gpitfield/filmstrip/site"
+	"github.com/spf13/viper"
+)
+
+const (
+	ARCHIVE = "archive" // whether to publish galleries of every image by the year and month it was taken
+)
+
+// Period is a year or month of the archive and the published images taken in it
+type Period struct {
+	Name   string // e.g. 2019 or June 2019
+	Path   string // public site path of the period's gallery, e.g. /archive/2019/06
+	Images []PrintInfo
+	Months []*Period // of a year, in calendar order
+}
+
+// Count returns the number of images taken in the period
+func (p *Period) Count() int { return len(p.Images) }
+
+// Link returns the URL of the period's gallery
+func (p *Period) Link() string { return p.Path + "/index.html" }
+
+// archiving reports whether archive pages are published, which they are unless turned off
+func archiving() bool {
+	return !viper.IsSet(ARCHIVE) || viper.GetBool(ARCHIVE)
+}
+
+// collectPeriods groups the given images by the year and month they were taken, newest year first, leaving out those
+// without a capture date
+func collectPeriods(images []PrintInfo) (years []*Period) {
+	byYear := map[int]*Period{}
+	byMonth := map[string]*Period{}
+	for _, info := range images {
+		if info.Date.IsZero() {
+			continue
+		}
+		year, month := info.Date.Year(), info.Date.Month()
+		y, ok := byYear[year]
+		if !ok {
+			y = &Period{Name: fmt.Sprint(year), Path: fmt.Sprintf("/%s/%d", site.ArchiveDir, year)}
+			byYear[year] = y
+			years = append(years, y)
+		}
+		y.Images = append(y.Images, info)
+		key := fmt.Sprintf("%d/%02d", year, month)
+		m, ok := byMonth[key]
+		if !ok {
+			m = &Period{Name: info.Date.Format("January 2006"), Path: "/" + site.ArchiveDir + "/" + key}
+			byMonth[key] = m
+			y.Months = append(y.Months, m)
+		}
+		m.Images = append(m.Images, info)
+	}
+	sort.Slice(years, func(i, j int) bool { return years[i].Path > years[j].Path })
+	for _, y := range years {
+		sort.Slice(y.Months, func(i, j int) bool { return y.Months[i].Path < y.Months[j].Path })
+	}
+	return
+}
+
+// buildArchive publishes an index of the years and months in which published images were taken, and a gallery of the
+// images of each year and month in the order they were taken, linking to their pages in their own collections and to
+// the periods before and after
+func buildArchive() {
+	if !archiving() {
+		return
+	}
+	years := collectPeriods(manifest.Published())
+	if len(years) == 0 {
+		return
+	}
+	var months []*Period
+	for i := len(years) - 1; i >= 0; i-- {
+		months = append(months, years[i].Months...)
+	}
+	// years are listed newest first, but galleries link to the earlier period as the previous one
+	for i, y := range years {
+		var previous, next *Period
+		if i < len(years)-1 {
+			previous = years[i+1]
+		}
+		if i > 0 {
+			next = years[i-1]
+		}
+		writePeriod(y, previous, next)
+	}
+	for i, m := range months {
+		var previous, next *Period
+		if i > 0 {
+			previous = months[i-1]
+		}
+		if i < len(months)-1 {
+			next = months[i+1]
+		}
+		writePeriod(m, previous, next)
+	}
+	writePage("/"+site.ArchiveDir+"/index.html", renderArchive(years).Bytes())
+}
+
+// writePeriod writes the archive gallery of a year or month
+func writePeriod(period, previous, next *Period) {
+	linkCollections(period.Images)
+	sortImages(period.Images, "date", nil)
+	checkErr(os.MkdirAll(site.PubSiteDir+period.Path, os.ModeDir|os.ModePerm))
+	writePage(period.Link(), renderPeriod(period, previous, next).Bytes())
+}
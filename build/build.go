@@ -3,11 +3,11 @@ package build
 import (
 	"bytes"
 	"fmt"
-	_ "image/jpeg"
-	"io"
 	"io/ioutil"
 	"os"
-	"sort"
+	"path"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gpitfield/filmstrip/asset"
@@ -18,51 +18,130 @@ import (
 	"github.com/spf13/viper"
 )
 
-var sourceLocation string
+var (
+	sourceLocation string
+	previous       *Manifest // manifest of the last build, used to skip unchanged images
+	manifest       *Manifest // manifest of the build in progress
+	scope          []string  // source paths a partial build is limited to, or nil to build every collection
+)
 
 func init() {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".") // config file in working directory
-	err := viper.ReadInConfig()
-	if err != nil {
+	err := ReloadConfig()
+	if _, missing := err.(viper.ConfigFileNotFoundError); missing {
+		log.Warn("no config file found, using the defaults")
+	} else if err != nil {
 		panic(fmt.Errorf("Fatal config file error: %s \n", err))
 	}
-	sourceLocation = viper.GetString("source-dir") // build needs a source folder
 	exif.RegisterParsers(mknote.All...)
 }
 
+// ReloadConfig re-reads the config file, so that a long-running process picks up edits to it
+func ReloadConfig() (err error) {
+	err = viper.ReadInConfig()
+	if err != nil {
+		return
+	}
+	sourceLocation = viper.GetString("source-dir") // build needs a source folder
+	return
+}
+
 // Build the site from the provided image content where changed. If force is true, regenerate the site regardless of changes,
 // typically if the HTML has changed. --force does not re-cut unchanged images, it just regenerates associated HTML.
-func Build(force bool) {
+// Any files in the public site that the build did not generate are pruned, or just listed if dryRun is true. Nothing is
+// built if a configured image format can't be encoded.
+func Build(force, dryRun bool) error {
+	if err := asset.CheckFormats(); err != nil {
+		return err
+	}
+	build(nil, force, dryRun)
+	return nil
+}
+
+// BuildChanged rebuilds only the collections containing the given changed source paths, along with the cover pages of
+// their parent collections, reusing the last build for every other collection. It returns the public site paths
+// that were written and pruned, both relative to the public site.
+func BuildChanged(paths []string, force bool) (written, pruned []string) {
+	var changed []string
+	root, err := filepath.Abs(sourceLocation)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil && strings.HasPrefix(abs, root+string(filepath.Separator)) {
+			changed = append(changed, filepath.ToSlash(strings.TrimPrefix(abs, root)))
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	if err = asset.CheckFormats(); err != nil {
+		log.Error(err)
+		return
+	}
+	return build(changed, force, false)
+}
+
+func build(changed []string, force, dryRun bool) (written, pruned []string) {
 	start := time.Now()
+	if _, err := os.Stat(sourceLocation); err != nil {
+		log.Error(err) // without a source there is nothing to build, and pruning would empty the site
+		return
+	}
+	scope = changed
 	site.Scaffold()
+	previous = loadManifest()
+	manifest = newManifest()
+	resetCollections()
 	buildCollection("", "", force)
+	buildTags()
+	buildArchive()
+	buildMap()
+	buildFeeds()
 	buildAbout()
+	buildSitemap()
+	manifest.save()
 	log.Infof("built in %v", time.Since(start))
+	return manifest.Written(), site.Prune(manifest.Outputs(), dryRun)
+}
+
+// Prune removes any files from the public site that were not generated by the last build, or just lists them if
+// dryRun is true
+func Prune(dryRun bool) {
+	m := loadManifest()
+	if len(m.Sources) == 0 && len(m.Pages) == 0 {
+		log.Warn("no build manifest found, run a build before pruning")
+		return
+	}
+	site.Prune(m.Outputs(), dryRun)
 }
 
 // buildCollection recursively builds collections from a given name and path prefix
 func buildCollection(prefix, name string, force bool) (coverInfo PrintInfo) {
-	collectionDirs(prefix, name)
 	var (
 		imageInfo     []PrintInfo
 		collName      string
 		cover         bool
 		order         int
-		validFiles    = map[string]bool{"index.html": true}
 		inPath        = prefix
-		outPath       = site.LowerDash(prefix)
+		outPath       string
 		outImagesPath string
 	)
 
 	if name != "" {
 		collName, order, _, _ = asset.FileInfo(name)
 		inPath += "/" + name
-		outPath += "/" + site.LowerDash(collName)
 	}
-	site.Flush(prefix, name)
+	meta := readCollection(inPath)
+	if meta.Title != "" && name != "" {
+		collName = meta.Title
+	}
+	outPath = collectionPath(inPath)
 	outImagesPath = outPath + "/" + site.ImagesDir
+	collectionDirs(outPath)
 
 	files, err := ioutil.ReadDir(sourceLocation + "/" + inPath)
 	if err != nil {
@@ -70,98 +149,163 @@ func buildCollection(prefix, name string, force bool) (coverInfo PrintInfo) {
 	}
 	log.Infof("building collection %s/%s (%d files)", prefix, name, len(files))
 
-	// recursively cut and copy changed/new images to local public site images
+	// recursively build sub-collections, queueing this collection's images to be cut
+	var jobs []cutJob
+	sidecars := readCollectionSidecars(inPath)
 	for _, file := range files {
 		if file.IsDir() {
-			imageInfo = append(imageInfo, buildCollection(inPath, file.Name(), force))
 			cover = true
+			if sub := inPath + "/" + file.Name(); !inScope(sub) {
+				if last := previous.Collection(sub); last != nil {
+					manifest.carryOver(previous, sub, collectionPath(sub))
+					imageInfo = append(imageInfo, *last)
+					continue
+				}
+			}
+			imageInfo = append(imageInfo, buildCollection(inPath, file.Name(), force))
 			continue
 		} else if collName == "" { // ignore any images at the topmost level
 			continue
-		} else if file.Name() == ".DS_Store" {
+		} else if file.Name() == ".DS_Store" || isSidecar(file.Name()) {
 			continue
 		}
-		// see if file has changed
-		source, _ := ioutil.ReadFile(sourceLocation + inPath + "/" + file.Name())
-		inHash := asset.Hash(source)
-		dest, _ := ioutil.ReadFile(site.PubSiteDir + outImagesPath + "/" + site.LowerDash(file.Name()))
-		outHash := asset.Hash(dest)
-		info := getInfo(file.Name(), io.Reader(bytes.NewReader(source)))
-		validFiles[stripExtension(file.Name())] = true
-		srcs := asset.RespImages(sourceLocation+inPath+"/"+file.Name(), site.PubSiteDir+outImagesPath, site.LowerDash(stripExtension(info.Filename)), extension(info.Filename), inHash == outHash)
-		info.SrcImages = srcs
-		info.AbsURL = outPath + "/" + info.RelURL
-		imageInfo = append(imageInfo, info)
-		for _, src := range srcs {
-			validFiles[src.Name] = true
-		}
+		jobs = append(jobs, cutJob{
+			index:         len(jobs),
+			inPath:        inPath,
+			outPath:       outPath,
+			outImagesPath: outImagesPath,
+			filename:      file.Name(),
+			sidecar:       readSidecar(sourceLocation + inPath + "/" + file.Name()).merge(lookupSidecar(sidecars, file.Name())),
+		})
 	}
 
-	sort.Sort(Ordered(imageInfo))
+	// cut and copy changed/new images to local public site images
+	imageInfo = append(imageInfo, cutImages(jobs)...)
+
+	sortImages(imageInfo, meta.Sort, meta.Order)
 	var page, gallery *bytes.Buffer
-	if len(imageInfo) > 0 {
-		coverInfo = imageInfo[0] // default
+	listed := visible(imageInfo)
+	if len(listed) > 0 {
+		coverInfo = listed[0] // default
 	}
 	// generate the HTML for each image as well as the gallery using imageInfo
+	chosen, unlisted := false, collectionHidden(inPath)
 	for _, info := range imageInfo {
-		if info.Cover {
+		if meta.isCover(info) {
+			coverInfo, chosen = info, true
+		} else if info.Cover && !chosen {
 			coverInfo = info
 		}
-		validFiles[site.LowerDash(info.Title)+".html"] = true
-		validFiles[stripExtension(info.Filename)] = true
-		validFiles[info.Title] = true
-
-		if !cover && info.IncludesExif {
-			page = renderDetail(collName, info, imageInfo)
-			err = ioutil.WriteFile(site.PubSiteDir+"/"+site.LowerDash(collName)+"/"+site.LowerDash(info.Title)+".html", page.Bytes(), 0644)
-			if err != nil {
-				log.Error(err)
+		if !cover {
+			var (
+				tags []NavInfo
+				link string
+			)
+			if !info.Hidden && !unlisted {
+				tags, link = tagLinks(info.Keywords), mapLink(info)
 			}
+			page = renderDetail(collName, info, listed, tags, link)
+			writePage(outPath+"/"+info.Slug+".html", page.Bytes())
+			published := info
+			published.Hidden = info.Hidden || unlisted
+			manifest.SetImage(inPath+"/"+info.Filename, published)
 		}
 	}
 	coverInfo.Title = collName
-	coverInfo.FileURL = site.LowerDash(collName)
+	coverInfo.FileURL = path.Base(outPath)
 	coverInfo.Order = order
-	gallery = renderGallery(collName, imageInfo, cover)
-	err = ioutil.WriteFile(site.PubSiteDir+"/"+site.LowerDash(collName)+"/index.html", gallery.Bytes(), 0644)
-	if err != nil {
-		log.Error(err)
+	coverInfo.Hidden = meta.Hidden
+	if !cover && !unlisted {
+		writeGeoJSON(outPath, listed)
 	}
+	gallery = renderGallery(collName, meta, listed, cover)
+	writePage(outPath+"/index.html", gallery.Bytes())
+	manifest.SetCollection(inPath, coverInfo)
+	return
+}
 
-	site.FlushInvalid(site.PubSiteDir+outPath, validFiles) // flush any files associated with removed images
+// visible returns the images that aren't hidden, which are those listed in their gallery
+func visible(images []PrintInfo) (listed []PrintInfo) {
+	for _, info := range images {
+		if !info.Hidden {
+			listed = append(listed, info)
+		}
+	}
 	return
 }
 
-func collectionDirs(parent, collection string) {
-	mode := os.ModeDir | os.ModePerm
-	if collection == "" {
-		checkErr(os.Mkdir(site.LowerDash(site.PubSiteDir+"/"+parent+"/"+site.ImagesDir), mode))
-	} else {
-		checkErr(os.Mkdir(site.LowerDash(site.PubSiteDir+"/"+parent+"/"+collection), mode))
-		checkErr(os.Mkdir(site.LowerDash(site.PubSiteDir+"/"+parent+"/"+collection)+"/"+site.ImagesDir, mode))
+// linkCollections points each image at its own collection, for pages that list images from across the site
+func linkCollections(images []PrintInfo) {
+	for i := range images {
+		images[i].Dir = path.Dir(images[i].AbsURL) + "/"
 	}
 }
 
-// func buildAbout(navs []NavInfo) {
-func buildAbout() {
-	about := renderAbout()
-	err := ioutil.WriteFile(site.PubSiteDir+"/"+site.AboutDir+"/index.html", about.Bytes(), 0644)
-	if err != nil {
-		log.Error(err)
+// inScope reports whether the collection at the given source path contains, or is, one of the changed paths of a
+// partial build. A changed collection.yml or images.yml puts every collection under its directory in scope, since the
+// slugs and visibility it sets apply to them all.
+func inScope(inPath string) bool {
+	if scope == nil {
+		return true
+	}
+	for _, changed := range scope {
+		if changed == inPath || strings.HasPrefix(changed, inPath+"/") {
+			return true
+		}
+		if name := path.Base(changed); name == collectionFile || name == collectionSidecar {
+			if dir := strings.TrimSuffix(path.Dir(changed), "/"); strings.HasPrefix(inPath, dir+"/") {
+				return true
+			}
+		}
 	}
+	return false
+}
 
-	inCopy, err := os.Open(viper.GetString("about-image"))
-	if err != nil {
-		log.Error(err)
+// collectionPath returns the public site path of the collection at the given source path, stripping any ordering
+// prefix from each of its directory names unless its collection.yml sets a slug
+func collectionPath(inPath string) (outPath string) {
+	var dirPath string
+	for _, dir := range strings.Split(inPath, "/") {
+		if dir == "" {
+			continue
+		}
+		dirPath += "/" + dir
+		name, _, _, _ := asset.FileInfo(dir)
+		if slug := safeSlug(readCollection(dirPath).Slug); slug != "" {
+			name = slug
+		}
+		outPath += "/" + site.LowerDash(name)
+	}
+	return
+}
+
+// collectionDirs creates the public site directories for the collection at outPath
+func collectionDirs(outPath string) {
+	if outPath == "" {
+		return // the root collection has no images of its own
 	}
-	defer inCopy.Close()
-	outFile, err := os.Create(site.PubSiteDir + "/" + site.AboutDir + "/" + "about.jpg")
+	checkErr(os.MkdirAll(site.PubSiteDir+outPath+"/"+site.ImagesDir, os.ModeDir|os.ModePerm))
+}
+
+// writePage writes a generated file to path relative to the public site, and records it as a build output
+func writePage(path string, b []byte) {
+	err := ioutil.WriteFile(site.PubSiteDir+path, b, 0644)
 	if err != nil {
 		log.Error(err)
+		return
 	}
-	_, err = io.Copy(outFile, inCopy) // use an exact copy so the hash doesn't mutate
+	manifest.AddPage(path)
+}
+
+// func buildAbout(navs []NavInfo) {
+func buildAbout() {
+	about := renderAbout()
+	writePage("/"+site.AboutDir+"/index.html", about.Bytes())
+
+	err := asset.CopyImage(viper.GetString("about-image"), site.PubSiteDir+"/"+site.AboutDir+"/"+"about.jpg")
 	if err != nil {
 		log.Error(err)
+		return
 	}
-	outFile.Close()
+	manifest.AddPage("/" + site.AboutDir + "/" + "about.jpg")
 }
@@ -0,0 +1,147 @@
+package build
+
+import (
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gpitfield/filmstrip/site"
+)
+
+// inSite changes into a temporary directory holding the given source files for the duration of the test, creating a
+// small JPEG for any file ending in .jpg
+func inSite(t *testing.T, files map[string]string) {
+	dir, err := ioutil.TempDir("", "filmstrip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	location := sourceLocation
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+		sourceLocation = location
+	})
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	sourceLocation = "src"
+	for name, contents := range files {
+		writeSource(t, name, contents)
+	}
+}
+
+// writeSource writes a file of the site's source
+func writeSource(t *testing.T, name, contents string) {
+	path := filepath.Join(sourceLocation, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if strings.HasSuffix(name, ".jpg") {
+		err = jpeg.Encode(out, image.NewGray(image.Rect(0, 0, 64, 48)), nil)
+	} else {
+		_, err = out.WriteString(contents)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// published reports whether the file is in the public site
+func published(path string) bool {
+	_, err := os.Stat(site.PubSiteDir + path)
+	return err == nil
+}
+
+func TestInScope(t *testing.T) {
+	defer func() { scope = nil }()
+	scope = []string{"/Travel/collection.yml", "/Portraits/bob.jpg"}
+	tests := map[string]bool{
+		"":                 true,
+		"/Travel":          true,
+		"/Travel/Sub":      true,
+		"/Travel/Sub/Deep": true,
+		"/Traveling":       false,
+		"/Portraits":       true,
+		"/Scans":           false,
+	}
+	for inPath, want := range tests {
+		if got := inScope(inPath); got != want {
+			t.Errorf("inScope(%q) = %t, want %t", inPath, got, want)
+		}
+	}
+	scope = []string{"/collection.yml"}
+	if !inScope("/Scans") {
+		t.Error("a change to the root collection.yml should rebuild every collection")
+	}
+}
+
+func TestCollectionPathSlug(t *testing.T) {
+	inSite(t, map[string]string{
+		"_1_Travel/collection.yml":       "slug: ../../etc\n",
+		"_1_Travel/Sub/collection.yml":   "slug: a/b\n",
+		"_1_Travel/Other/collection.yml": "slug: ..\n",
+	})
+	resetCollections()
+	tests := map[string]string{
+		"/_1_Travel":       "/etc",
+		"/_1_Travel/Sub":   "/etc/a-b",
+		"/_1_Travel/Other": "/etc/other",
+	}
+	for inPath, want := range tests {
+		if got := collectionPath(inPath); got != want {
+			t.Errorf("collectionPath(%q) = %q, want %q", inPath, got, want)
+		}
+	}
+}
+
+func TestBuildChangedParentSlug(t *testing.T) {
+	inSite(t, map[string]string{
+		"Travel/collection.yml": "title: Travel\n",
+		"Travel/Sub/road.jpg":   "",
+		"Scans/scan.jpg":        "",
+	})
+	Build(false, false)
+	if !published("/travel/sub/road.html") {
+		t.Fatal("first build didn't publish /travel/sub/road.html")
+	}
+
+	writeSource(t, "Travel/collection.yml", "title: Travel\nslug: trips\n")
+	BuildChanged([]string{filepath.Join(sourceLocation, "Travel", collectionFile)}, false)
+	for _, path := range []string{"/trips/index.html", "/trips/sub/index.html", "/trips/sub/road.html",
+		"/trips/sub/images/road.jpg", "/scans/scan.html"} {
+		if !published(path) {
+			t.Errorf("%s wasn't published under the new slug", path)
+		}
+	}
+	if published("/travel") {
+		t.Error("the old /travel directory wasn't pruned")
+	}
+	if entry := manifest.Source("/Travel/Sub/road.jpg"); entry == nil || len(entry.Renditions) == 0 ||
+		!strings.HasPrefix(entry.Renditions[0], "/trips/sub/") {
+		t.Error("manifest doesn't record the renditions of road.jpg under /trips/sub/")
+	}
+}
+
+func TestBuildChangedParentHidden(t *testing.T) {
+	inSite(t, map[string]string{
+		"Travel/Sub/road.jpg": "",
+		"Scans/scan.jpg":      "",
+	})
+	Build(false, false)
+
+	writeSource(t, "Travel/collection.yml", "hidden: true\n")
+	BuildChanged([]string{filepath.Join(sourceLocation, "Travel", collectionFile)}, false)
+	if info, ok := manifest.Images["/Travel/Sub/road.jpg"]; !ok || !info.Hidden {
+		t.Error("road.jpg in a newly hidden collection isn't recorded as hidden")
+	}
+}
@@ -0,0 +1,128 @@
+package build
+
+import (
+	"io"
+	"strings"
+
+	"github.com/gpitfield/filmstrip/asset"
+	log "github.com/gpitfield/relog"
+	"github.com/spf13/viper"
+)
+
+const (
+	METADATA_PRECEDENCE = "metadata.precedence"
+
+	exifSource = "exif"
+	xmpSource  = "xmp"
+	iptcSource = "iptc"
+)
+
+// defaultPrecedence is the order in which the metadata sources supply each field, unless configured otherwise
+var defaultPrecedence = []string{exifSource, xmpSource, iptcSource}
+
+// Caption is the descriptive metadata of an image as recorded in one place, such as its EXIF or XMP, so that what
+// each records can be merged in order of precedence
+type Caption struct {
+	Title       string
+	Description string
+	Copyright   string
+	Keywords    []string
+	Rating      int
+	Label       string
+	Location    string
+	Byline      string // who made the image
+	Credit      string // who to credit for the image, such as an agency
+}
+
+// merge returns the caption with any field it leaves empty taken from other
+func (c Caption) merge(other Caption) Caption {
+	if c.Title == "" {
+		c.Title = other.Title
+	}
+	if c.Description == "" {
+		c.Description = other.Description
+	}
+	if c.Copyright == "" {
+		c.Copyright = other.Copyright
+	}
+	if len(c.Keywords) == 0 {
+		c.Keywords = other.Keywords
+	}
+	if c.Rating == 0 {
+		c.Rating = other.Rating
+	}
+	if c.Label == "" {
+		c.Label = other.Label
+	}
+	if c.Location == "" {
+		c.Location = other.Location
+	}
+	if c.Byline == "" {
+		c.Byline = other.Byline
+	}
+	if c.Credit == "" {
+		c.Credit = other.Credit
+	}
+	return c
+}
+
+// readCaptions reads the XMP and IPTC captions of the source image at fullPath from in, leaving in rewound
+func readCaptions(fullPath string, in io.ReadSeeker) (captions map[string]Caption) {
+	captions = map[string]Caption{xmpSource: xmpCaption(fullPath, in)}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		log.Error(err)
+	}
+	captions[iptcSource] = parseIPTC(asset.ReadIPTC(in))
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		log.Error(err)
+	}
+	return
+}
+
+// mergeCaptions merges the captions of each source, each field taken from the first source in order of precedence
+// that has it
+func mergeCaptions(captions map[string]Caption) (merged Caption) {
+	for _, source := range captionPrecedence() {
+		merged = merged.merge(captions[source])
+	}
+	return
+}
+
+// captionPrecedence returns the known sources in the order listed in metadata.precedence, followed by any it leaves out
+// in their default order
+func captionPrecedence() (order []string) {
+	seen := map[string]bool{}
+	for _, source := range append(viper.GetStringSlice(METADATA_PRECEDENCE), defaultPrecedence...) {
+		source = strings.ToLower(source)
+		known := false
+		for _, s := range defaultPrecedence {
+			known = known || s == source
+		}
+		if known && !seen[source] {
+			seen[source] = true
+			order = append(order, source)
+		}
+	}
+	return
+}
+
+// joinLocation joins the non-empty parts of a location from most to least specific, skipping repeats
+func joinLocation(parts ...string) string {
+	var kept []string
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		repeat := false
+		for _, k := range kept {
+			if strings.EqualFold(k, part) {
+				repeat = true
+			}
+		}
+		if !repeat {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, ", ")
+}
@@ -0,0 +1,136 @@
+package build
+
+import (
+	"html/template"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gpitfield/filmstrip/asset"
+	"github.com/gpitfield/filmstrip/site"
+	log "github.com/gpitfield/relog"
+	"github.com/russross/blackfriday"
+)
+
+// collectionFile is the optional file in a collection's source directory describing the collection
+const collectionFile = "collection.yml"
+
+var (
+	collections   map[string]CollectionInfo // collection.yml of each collection read this build, keyed by source path
+	collectionsMu sync.Mutex
+)
+
+// CollectionInfo is what a collection's collection.yml says about it, overriding what is otherwise taken from its
+// directory name
+type CollectionInfo struct {
+	Title       string   `yaml:"title"`
+	Slug        string   `yaml:"slug"`        // name of the collection's directory in the public site
+	Description string   `yaml:"description"` // Markdown, shown above the gallery
+	From        string   `yaml:"from"`        // first and last dates of the work in the collection
+	To          string   `yaml:"to"`
+	Cover       string   `yaml:"cover"`     // file name of the image, or directory name of the sub-collection, to use as cover
+	Sort        string   `yaml:"sort"`      // in place of the site's sort mode
+	Order       []string `yaml:"order"`     // images or sub-collections to list first, in this order
+	Columns     int      `yaml:"columns"`   // in place of gallery-columns or cover-columns
+	Hidden      bool     `yaml:"hidden"`    // left out of its parent's covers, though its pages are still published
+	Watermark   *bool    `yaml:"watermark"` // false to publish its images, and those of its sub-collections, unwatermarked
+}
+
+// resetCollections forgets the collection.yml files read by the last build, so that edits to them are picked up
+func resetCollections() {
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+	collections = map[string]CollectionInfo{}
+}
+
+// readCollection returns the collection.yml of the collection at the given source path, reading it once per build
+func readCollection(inPath string) (info CollectionInfo) {
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+	if info, ok := collections[inPath]; ok {
+		return info
+	}
+	if stat, err := os.Stat(sourceLocation + inPath); err == nil && stat.IsDir() {
+		readYAML(sourceLocation+inPath+"/"+collectionFile, &info)
+	}
+	if collections != nil {
+		collections[inPath] = info
+	}
+	return
+}
+
+// collectionHidden reports whether the collection at the given source path, or any collection it's in, is hidden
+func collectionHidden(inPath string) bool {
+	var dirPath string
+	for _, dir := range strings.Split(inPath, "/") {
+		if dir == "" {
+			continue
+		}
+		dirPath += "/" + dir
+		if readCollection(dirPath).Hidden {
+			return true
+		}
+	}
+	return false
+}
+
+// collectionWatermarked reports whether the images of the collection at the given source path are watermarked, as set
+// by the nearest collection.yml above them that says, and otherwise true
+func collectionWatermarked(inPath string) bool {
+	watermarked := true
+	var dirPath string
+	for _, dir := range strings.Split(inPath, "/") {
+		if dir == "" {
+			continue
+		}
+		dirPath += "/" + dir
+		if set := readCollection(dirPath).Watermark; set != nil {
+			watermarked = *set
+		}
+	}
+	return watermarked
+}
+
+// DescriptionHTML returns the collection's description rendered from Markdown
+func (c CollectionInfo) DescriptionHTML() template.HTML {
+	if c.Description == "" {
+		return ""
+	}
+	return template.HTML(blackfriday.MarkdownCommon([]byte(c.Description)))
+}
+
+// DateRange returns the dates of the collection as months, e.g. "June – August 2019", or "" if it has none
+func (c CollectionInfo) DateRange() string {
+	from, okFrom := parseDate(c.From)
+	to, okTo := parseDate(c.To)
+	if !okFrom && !okTo {
+		if c.From != "" || c.To != "" {
+			log.Errorf("unreadable collection dates %s to %s", c.From, c.To)
+		}
+		return ""
+	}
+	switch {
+	case !okTo:
+		return from.Format("January 2006")
+	case !okFrom:
+		return to.Format("January 2006")
+	case from.Year() != to.Year():
+		return from.Format("January 2006") + " – " + to.Format("January 2006")
+	case from.Month() != to.Month():
+		return from.Format("January") + " – " + to.Format("January 2006")
+	}
+	return from.Format("January 2006")
+}
+
+// isCover reports whether the collection.yml names the given image or sub-collection as the collection's cover
+func (c CollectionInfo) isCover(info PrintInfo) bool {
+	return c.Cover != "" && matchesName(c.Cover, info)
+}
+
+// matchesName reports whether name, as written in a collection.yml, is the file name of the given image with or
+// without its ordering prefix, or the directory name of the given sub-collection
+func matchesName(name string, info PrintInfo) bool {
+	stripped, _, _, _ := asset.FileInfo(name)
+	file, _, _, _ := asset.FileInfo(info.Filename)
+	return name == info.Filename || stripped == file || site.LowerDash(stripped) == info.FileURL
+}
@@ -0,0 +1,167 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gpitfield/filmstrip/asset"
+	"github.com/gpitfield/filmstrip/site"
+	log "github.com/gpitfield/relog"
+	"github.com/spf13/viper"
+)
+
+const (
+	BUILD_WORKERS = "build-workers"
+)
+
+// cutJob is a single source image to be read, hashed, decoded and cut into its responsive sizes
+type cutJob struct {
+	index         int    // position of the image within its collection listing
+	inPath        string // collection path relative to the source location
+	outPath       string // collection path relative to the public site
+	outImagesPath string
+	filename      string
+	sidecar       Sidecar // overrides from the image's sidecar and its collection's images.yml
+}
+
+// cutImages cuts the given images on a bounded pool of workers, and returns their PrintInfo in job order, leaving
+// out any files that turned out not to be images
+func cutImages(jobs []cutJob) (images []PrintInfo) {
+	infos := make([]PrintInfo, len(jobs))
+	if len(jobs) == 0 {
+		return
+	}
+	workers := buildWorkers()
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	queue := make(chan cutJob)
+	done := make(chan bool)
+	for i := 0; i < workers; i++ {
+		go cutFiles(queue, infos, done)
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	for i := 0; i < workers; i++ {
+		<-done
+	}
+	for _, info := range infos {
+		if info.Filename != "" {
+			images = append(images, info)
+		}
+	}
+	return
+}
+
+// cutFiles works through queued jobs, writing each result to its own index of infos
+func cutFiles(jobs chan cutJob, infos []PrintInfo, done chan bool) {
+	for {
+		select {
+		case job, ok := <-jobs:
+			if !ok {
+				done <- true
+				return
+			}
+			infos[job.index] = cutImage(job)
+		}
+	}
+}
+
+// cutImage reads the image metadata and cuts its responsive sizes if it, or the config it was cut with, has changed
+func cutImage(job cutJob) (info PrintInfo) {
+	var (
+		srcPath     = job.inPath + "/" + job.filename
+		fullPath    = sourceLocation + srcPath
+		watermark   = watermarked(srcPath, job.sidecar.Watermark)
+		fingerprint = asset.Fingerprint(watermark, job.sidecar.Focus)
+		entry       = &SourceEntry{Fingerprint: fingerprint}
+	)
+	if !asset.IsImage(fullPath) {
+		log.Infof("skipping %s, which is not an image", srcPath)
+		return
+	}
+	stat, err := os.Stat(fullPath)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	entry.Size = stat.Size()
+	entry.ModTime = stat.ModTime()
+
+	// see if file has changed, only reading it in full if its size or modification time differ from the last build
+	prev := previous.Source(srcPath)
+	entry.Published = time.Now().UTC().Truncate(time.Second)
+	if prev != nil && !prev.Published.IsZero() {
+		entry.Published = prev.Published
+	}
+	metaOnly := prev != nil && prev.Fingerprint == fingerprint && prev.renditionsExist(job.outImagesPath) && prev.Preview.Color != ""
+	if metaOnly && prev.unchanged(stat) {
+		entry.Hash = prev.Hash
+	} else {
+		source, err := ioutil.ReadFile(fullPath)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		entry.Hash = asset.Hash(source)
+		metaOnly = metaOnly && prev.Hash == entry.Hash
+	}
+
+	in, err := os.Open(fullPath)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	captions := readCaptions(fullPath, in)
+	info = getInfo(job.filename, asset.ExifReader(in), captions)
+	in.Close()
+	info.ModTime = stat.ModTime()
+	info.Published = entry.Published
+	job.sidecar.apply(&info)
+	info.SrcImages, entry.Preview = asset.RespImages(fullPath, site.PubSiteDir+job.outImagesPath, site.LowerDash(stripExtension(info.Filename)), extension(info.Filename), info.Orientation, job.sidecar.Focus, watermark, metaOnly)
+	if metaOnly {
+		entry.Preview = prev.Preview
+	}
+	info.Preview = entry.Preview
+	if len(info.SrcImages) > 0 {
+		info.Width, info.Height = info.SrcImages[0].Bounds.Dx(), info.SrcImages[0].Bounds.Dy()
+	}
+	info.AbsURL = job.outPath + "/" + info.RelURL
+	for _, src := range info.SrcImages {
+		entry.Renditions = append(entry.Renditions, job.outImagesPath+"/"+src.Name)
+	}
+	manifest.SetSource(srcPath, entry)
+	if !metaOnly {
+		manifest.AddWritten(entry.Renditions...)
+	}
+	return
+}
+
+// watermarked reports whether the image at the given source path should be watermarked. It isn't if it or one of its
+// collections is listed in watermark.skip, whose paths are relative to the source folder and ignore order prefixes and
+// case. Otherwise its sidecar's watermark setting, if any, is followed, and then that of its collections.
+func watermarked(srcPath string, sidecar *bool) bool {
+	path := collectionPath(srcPath)
+	for _, skip := range viper.GetStringSlice(asset.WATERMARK_SKIP) {
+		if skip = collectionPath("/" + skip); skip != "" && (path == skip || strings.HasPrefix(path, skip+"/")) {
+			return false
+		}
+	}
+	if sidecar != nil {
+		return *sidecar
+	}
+	return collectionWatermarked(srcPath[:strings.LastIndex(srcPath, "/")])
+}
+
+// buildWorkers returns the configured size of the image cutting pool, defaulting to one worker per CPU
+func buildWorkers() int {
+	if workers := viper.GetInt(BUILD_WORKERS); workers > 0 {
+		return workers
+	}
+	return runtime.NumCPU()
+}
@@ -0,0 +1,44 @@
+package build
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/gpitfield/filmstrip/asset"
+	"github.com/spf13/viper"
+)
+
+func TestWatermarked(t *testing.T) {
+	inSite(t, map[string]string{
+		"_1_Travel/collection.yml":         "watermark: false\n",
+		"_1_Travel/Iceland/collection.yml": "watermark: true\n",
+	})
+	defer viper.Set(asset.WATERMARK_SKIP, viper.Get(asset.WATERMARK_SKIP))
+	viper.Set(asset.WATERMARK_SKIP, []string{"portraits/bob.jpg"})
+	resetCollections()
+	yes, no := true, false
+	tests := []struct {
+		srcPath string
+		sidecar *bool
+		want    bool
+	}{
+		{"/Scans/scan.jpg", nil, true},
+		{"/Scans/scan.jpg", &no, false},
+		{"/_1_Travel/road.jpg", nil, false},
+		{"/_1_Travel/road.jpg", &yes, true},
+		{"/_1_Travel/Iceland/tree.jpg", nil, true},
+		{"/_1_Travel/Iceland/Snow/tree.jpg", nil, true},
+		{"/_1_Travel/Iceland/Snow/tree.jpg", &no, false},
+		{"/Portraits/alice.jpg", nil, true},
+		{"/Portraits/bob.jpg", &yes, false}, // watermark.skip overrides everything else
+	}
+	for _, test := range tests {
+		sidecar := "unset"
+		if test.sidecar != nil {
+			sidecar = strconv.FormatBool(*test.sidecar)
+		}
+		if got := watermarked(test.srcPath, test.sidecar); got != test.want {
+			t.Errorf("watermarked(%q) with the sidecar's watermark %s = %t, want %t", test.srcPath, sidecar, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package build
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html/template"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gpitfield/filmstrip/site"
+	log "github.com/gpitfield/relog"
+	"github.com/spf13/viper"
+)
+
+const (
+	BASE_URL   = "base-url"   // absolute URL the site is deployed to, e.g. https://example.com
+	FEED_ITEMS = "feed.items" // number of the most recently published images each feed lists
+
+	defaultFeedItems = 20
+	atomFile         = "feed.xml"
+	rssFile          = "rss.xml"
+)
+
+// atomFeed and atomEntry are an Atom feed of images
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Rights  string      `xml:"rights,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Rights    string      `xml:"rights,omitempty"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// rssFeed and rssItem are an RSS 2.0 feed of images
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Copyright   string    `xml:"copyright,omitempty"`
+	PubDate     string    `xml:"pubDate,omitempty"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        string        `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Description string        `xml:"description"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// baseURL returns the configured absolute URL of the site, without a trailing slash
+func baseURL() string {
+	return strings.TrimRight(viper.GetString(BASE_URL), "/")
+}
+
+// feedItems returns the number of images each feed lists
+func feedItems() int {
+	if !viper.IsSet(FEED_ITEMS) {
+		return defaultFeedItems
+	}
+	return viper.GetInt(FEED_ITEMS)
+}
+
+// buildFeeds publishes Atom and RSS feeds of the most recently published images of the site at its root, and of each
+// collection in its directory. Feeds need absolute URLs, so are only published if base-url is set.
+func buildFeeds() {
+	if baseURL() == "" || feedItems() <= 0 {
+		return
+	}
+	images := manifest.Published()
+	writeFeeds("", viper.GetString("site-title"), images)
+
+	titles := manifest.CollectionTitles()
+	paths := make([]string, 0, len(titles))
+	for inPath := range titles {
+		paths = append(paths, inPath)
+	}
+	sort.Strings(paths)
+	for _, inPath := range paths {
+		if inPath == "" || collectionHidden(inPath) {
+			continue
+		}
+		outPath := collectionPath(inPath)
+		var listed []PrintInfo
+		for _, info := range images {
+			if strings.HasPrefix(info.AbsURL, outPath+"/") {
+				listed = append(listed, info)
+			}
+		}
+		writeFeeds(outPath, titles[inPath], listed)
+	}
+}
+
+// writeFeeds writes the Atom and RSS feeds of the given images into the public site directory at dir, listing the most
+// recently published first
+func writeFeeds(dir, title string, images []PrintInfo) {
+	if len(images) == 0 {
+		return
+	}
+	sort.SliceStable(images, func(i, j int) bool {
+		if !images[i].Published.Equal(images[j].Published) {
+			return images[i].Published.After(images[j].Published)
+		}
+		return images[i].Date.After(images[j].Date)
+	})
+	if len(images) > feedItems() {
+		images = images[:feedItems()]
+	}
+	base := baseURL()
+	link := base + dir + "/"
+	copyright := viper.GetString("copyright")
+	updated := images[0].Published
+
+	atom := atomFeed{
+		Title:   title,
+		ID:      link,
+		Links:   []atomLink{{Href: link}, {Href: base + dir + "/" + atomFile, Rel: "self", Type: "application/atom+xml"}},
+		Updated: updated.Format(time.RFC3339),
+		Rights:  copyright,
+	}
+	rss := rssFeed{Version: "2.0", Channel: rssChannel{
+		Title:       title,
+		Link:        link,
+		Description: "Recently published images of " + title,
+		Copyright:   copyright,
+		PubDate:     updated.Format(time.RFC1123Z),
+	}}
+	for _, info := range images {
+		url := base + path.Dir(info.AbsURL) + "/" + info.RelURL + ".html"
+		name := info.Title
+		if info.Untitled {
+			name = "Untitled"
+		}
+		src, size := feedRendition(info)
+		content := feedContent(info, src)
+		atom.Entries = append(atom.Entries, atomEntry{
+			Title:     name,
+			ID:        url,
+			Link:      atomLink{Href: url},
+			Published: info.Published.Format(time.RFC3339),
+			Updated:   info.Published.Format(time.RFC3339),
+			Rights:    info.Copyright,
+			Content:   atomContent{Type: "html", Body: content},
+		})
+		item := rssItem{
+			Title:       name,
+			Link:        url,
+			GUID:        url,
+			PubDate:     info.Published.Format(time.RFC1123Z),
+			Description: content,
+		}
+		if src != "" {
+			item.Enclosure = &rssEnclosure{URL: base + src, Length: size, Type: "image/jpeg"}
+		}
+		rss.Channel.Items = append(rss.Channel.Items, item)
+	}
+	writeXML(dir+"/"+atomFile, atom)
+	writeXML(dir+"/"+rssFile, rss)
+}
+
+// feedRendition returns the public site path and size of the middle JPEG rendition of the image, or "" if it has none
+func feedRendition(info PrintInfo) (src string, size int64) {
+	fallback := info.Fallback()
+	if len(fallback) == 0 {
+		return "", 0
+	}
+	src = path.Dir(info.AbsURL) + "/" + site.ImagesDir + "/" + fallback[len(fallback)/2].Name
+	if stat, err := os.Stat(site.PubSiteDir + src); err == nil {
+		size = stat.Size()
+	}
+	return
+}
+
+// feedContent returns the HTML of a feed entry: the image at the given public site path, followed by its description
+// and copyright
+func feedContent(info PrintInfo, src string) string {
+	var content string
+	if src != "" {
+		content = `<p><img src="` + template.HTMLEscapeString(baseURL()+src) + `" alt="` +
+			template.HTMLEscapeString(info.AltText()) + `"></p>`
+	}
+	if info.DescriptionHTML != "" {
+		content += string(info.DescriptionHTML)
+	}
+	if info.Copyright != "" {
+		content += "<p>© " + template.HTMLEscapeString(info.Copyright) + "</p>"
+	}
+	return content
+}
+
+// writeXML writes v as an XML document to path relative to the public site
+func writeXML(path string, v interface{}) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	var doc bytes.Buffer
+	doc.WriteString(xml.Header)
+	doc.Write(b)
+	writePage(path, doc.Bytes())
+}
@@ -0,0 +1,110 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gpitfield/filmstrip/asset"
+	"github.com/gpitfield/filmstrip/site"
+	"github.com/spf13/viper"
+)
+
+// goldenAtom and goldenRSS are the feeds of the images of TestWriteFeeds, the most recently published first
+const goldenAtom = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Travel</title>
+  <id>https://example.com/travel/</id>
+  <link href="https://example.com/travel/"></link>
+  <link href="https://example.com/travel/feed.xml" rel="self" type="application/atom+xml"></link>
+  <updated>2024-05-02T10:30:00Z</updated>
+  <rights>Jo Bloggs</rights>
+  <entry>
+    <title>Tram 28</title>
+    <id>https://example.com/travel/tram-28.html</id>
+    <link href="https://example.com/travel/tram-28.html"></link>
+    <published>2024-05-02T10:30:00Z</published>
+    <updated>2024-05-02T10:30:00Z</updated>
+    <rights>Jo &amp; co</rights>
+    <content type="html">&lt;p&gt;&lt;img src=&#34;https://example.com/travel/images/tram-800.jpg&#34; alt=&#34;Tram 28&#34;&gt;&lt;/p&gt;&lt;p&gt;Up the hill&lt;/p&gt;&lt;p&gt;© Jo &amp;amp; co&lt;/p&gt;</content>
+  </entry>
+  <entry>
+    <title>Untitled</title>
+    <id>https://example.com/travel/img_0001.html</id>
+    <link href="https://example.com/travel/img_0001.html"></link>
+    <published>2024-05-01T09:00:00Z</published>
+    <updated>2024-05-01T09:00:00Z</updated>
+    <content type="html"></content>
+  </entry>
+</feed>`
+
+const goldenRSS = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Travel</title>
+    <link>https://example.com/travel/</link>
+    <description>Recently published images of Travel</description>
+    <copyright>Jo Bloggs</copyright>
+    <pubDate>Thu, 02 May 2024 10:30:00 +0000</pubDate>
+    <item>
+      <title>Tram 28</title>
+      <link>https://example.com/travel/tram-28.html</link>
+      <guid>https://example.com/travel/tram-28.html</guid>
+      <pubDate>Thu, 02 May 2024 10:30:00 +0000</pubDate>
+      <description>&lt;p&gt;&lt;img src=&#34;https://example.com/travel/images/tram-800.jpg&#34; alt=&#34;Tram 28&#34;&gt;&lt;/p&gt;&lt;p&gt;Up the hill&lt;/p&gt;&lt;p&gt;© Jo &amp;amp; co&lt;/p&gt;</description>
+      <enclosure url="https://example.com/travel/images/tram-800.jpg" length="5" type="image/jpeg"></enclosure>
+    </item>
+    <item>
+      <title>Untitled</title>
+      <link>https://example.com/travel/img_0001.html</link>
+      <guid>https://example.com/travel/img_0001.html</guid>
+      <pubDate>Wed, 01 May 2024 09:00:00 +0000</pubDate>
+      <description></description>
+    </item>
+  </channel>
+</rss>`
+
+func TestWriteFeeds(t *testing.T) {
+	inSite(t, nil)
+	for _, key := range []string{BASE_URL, "copyright"} {
+		defer viper.Set(key, viper.Get(key))
+	}
+	viper.Set(BASE_URL, "https://example.com/")
+	viper.Set("copyright", "Jo Bloggs")
+	manifest = newManifest()
+	if err := os.MkdirAll(site.PubSiteDir+"/travel/"+site.ImagesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	rendition := site.PubSiteDir + "/travel/" + site.ImagesDir + "/tram-800.jpg"
+	if err := ioutil.WriteFile(rendition, []byte("12345"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	images := []PrintInfo{{
+		Title:     "IMG_0001",
+		Untitled:  true,
+		RelURL:    "img_0001",
+		AbsURL:    "/travel/img_0001.jpg",
+		Published: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC),
+	}, {
+		Title:           "Tram 28",
+		RelURL:          "tram-28",
+		AbsURL:          "/travel/tram-28.jpg",
+		Published:       time.Date(2024, 5, 2, 10, 30, 0, 0, time.UTC),
+		DescriptionHTML: "<p>Up the hill</p>",
+		Copyright:       "Jo & co",
+		SrcImages: []asset.SrcImage{{Name: "tram-400.jpg"}, {Name: "tram-800.jpg"}, {Name: "tram-1600.jpg"},
+			{Name: "tram-800.webp", MIME: "image/webp"}},
+	}}
+	writeFeeds("/travel", "Travel", images)
+	for file, want := range map[string]string{atomFile: goldenAtom, rssFile: goldenRSS} {
+		got, err := ioutil.ReadFile(site.PubSiteDir + "/travel/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s:\n%s\nwant:\n%s", file, got, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+
+	"github.com/gpitfield/filmstrip/site"
+	log "github.com/gpitfield/relog"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/spf13/viper"
+)
+
+const (
+	MAP_ENABLED     = "map.enabled"     // whether to publish where images were taken
+	MAP_TILES       = "map.tiles"       // URL of the map tiles, with {z}, {x} and {y} placeholders
+	MAP_ATTRIBUTION = "map.attribution" // credit for the map tiles, shown on the map
+
+	geoJSONFile = "locations.geojson"
+	mapZoom     = 15 // zoom the map is shown at when opened on a single image
+)
+
+// Position is where an image was taken, from its EXIF GPS tags
+type Position struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  float64 // in meters above sea level
+}
+
+// mapping reports whether the positions of images are published
+func mapping() bool {
+	return viper.GetBool(MAP_ENABLED)
+}
+
+// readPosition reads the GPS position recorded in EXIF metadata, returning nil if there isn't a usable one
+func readPosition(x *exif.Exif) *Position {
+	lat, long, err := x.LatLong()
+	if err != nil || math.IsNaN(lat) || math.IsNaN(long) || (lat == 0 && long == 0) {
+		return nil
+	}
+	pos := &Position{Latitude: lat, Longitude: long}
+	if tag, err := x.Get(exif.GPSAltitude); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && den != 0 {
+			pos.Altitude = float64(num) / float64(den)
+			if ref, err := x.Get(exif.GPSAltitudeRef); err == nil {
+				if below, err := ref.Int(0); err == nil && below == 1 {
+					pos.Altitude = -pos.Altitude
+				}
+			}
+		}
+	}
+	return pos
+}
+
+// mapLink returns the link to the map page opened on the image, or "" if the image's position isn't published
+func mapLink(info PrintInfo) string {
+	if !mapping() || info.Position == nil || viper.GetString(MAP_TILES) == "" {
+		return ""
+	}
+	return fmt.Sprintf("/%s/index.html#%d/%.5f/%.5f", site.MapDir, mapZoom, info.Position.Latitude,
+		info.Position.Longitude)
+}
+
+// featureCollection, feature and geometry are the parts of a GeoJSON document
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Type       string            `json:"type"`
+	Geometry   geometry          `json:"geometry"`
+	Properties map[string]string `json:"properties"`
+}
+
+type geometry struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+// geoJSON returns a GeoJSON collection of a point for each of the images with a position, with the title, page,
+// smallest rendition and date of the image as its properties. It returns nil if none of the images have a position.
+func geoJSON(images []PrintInfo) []byte {
+	collection := featureCollection{Type: "FeatureCollection"}
+	for _, info := range images {
+		if info.Position == nil {
+			continue
+		}
+		dir := path.Dir(info.AbsURL) + "/"
+		properties := map[string]string{"title": info.Title, "url": dir + info.RelURL + ".html"}
+		if info.Untitled {
+			properties["title"] = "Untitled"
+		}
+		if fallback := info.Fallback(); len(fallback) > 0 {
+			properties["thumbnail"] = dir + site.ImagesDir + "/" + fallback[len(fallback)-1].Name
+		}
+		if !info.Date.IsZero() {
+			properties["date"] = info.Date.Format("2006-01-02")
+		}
+		coordinates := []float64{info.Position.Longitude, info.Position.Latitude}
+		if info.Position.Altitude != 0 {
+			coordinates = append(coordinates, info.Position.Altitude)
+		}
+		collection.Features = append(collection.Features, feature{
+			Type:       "Feature",
+			Geometry:   geometry{Type: "Point", Coordinates: coordinates},
+			Properties: properties,
+		})
+	}
+	if len(collection.Features) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(collection)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	return b
+}
+
+// writeGeoJSON writes the GeoJSON of the given images into the public site directory at dir, if any have a position
+func writeGeoJSON(dir string, images []PrintInfo) {
+	if !mapping() {
+		return
+	}
+	if b := geoJSON(images); b != nil {
+		writePage(dir+"/"+geoJSONFile, b)
+	}
+}
+
+// buildMap publishes the GeoJSON of every published image with a position, and the map page showing them
+func buildMap() {
+	if !mapping() {
+		return
+	}
+	images := manifest.Published()
+	writeGeoJSON("", images)
+	if viper.GetString(MAP_TILES) == "" {
+		log.Warnf("%s is set, but there is no %s to draw the map with", MAP_ENABLED, MAP_TILES)
+		return
+	}
+	checkErr(os.MkdirAll(site.PubSiteDir+"/"+site.MapDir, os.ModeDir|os.ModePerm))
+	writePage("/"+site.MapDir+"/index.html", renderMap().Bytes())
+}
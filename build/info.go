@@ -1,13 +1,14 @@
 package build
 
 import (
+	"encoding/binary"
 	"fmt"
-	// "image"
-	_ "image/jpeg"
+	"html/template"
 	"io"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf16"
 
 	"github.com/gpitfield/filmstrip/asset"
 	"github.com/gpitfield/filmstrip/site"
@@ -17,21 +18,98 @@ import (
 )
 
 type PrintInfo struct {
-	Filename     string
-	Title        string
-	Untitled     bool
-	FileURL      string
-	RelURL       string // dash-spaced, lowercased, and escaped
-	AbsURL       string // dash-spaced, lowercased, and escaped
-	IncludesExif bool
-	Order        int
-	Description  string
-	Date         time.Time
-	DateString   string
-	CameraInfo   string
-	Copyright    string
-	Cover        bool
-	SrcImages    []asset.SrcImage
+	Filename        string
+	Title           string // from XMP, otherwise the file name
+	Slug            string // name of the image's page, from the file name unless its sidecar sets one
+	Untitled        bool
+	FileURL         string
+	RelURL          string // dash-spaced, lowercased, and escaped
+	AbsURL          string // dash-spaced, lowercased, and escaped
+	IncludesExif    bool
+	Order           int
+	Description     string
+	DescriptionHTML template.HTML // the description escaped, or rendered from Markdown if set by a sidecar
+	Date            time.Time
+	ModTime         time.Time // of the source file
+	Published       time.Time // when the image was first published
+	DateString      string
+	CameraInfo      string
+	Copyright       string
+	Cover           bool
+	Hidden          bool   // left out of its gallery and the previous and next links of its collection
+	Alt             string // text alternative to the image, from its sidecar
+	Keywords        []string
+	Rating          int    // star rating, from 0 to 5
+	Label           string // color label
+	Location        string // place the image was taken, from most to least specific
+	Byline          string
+	Credit          string
+	Orientation     int // EXIF orientation the renditions were turned upright from
+	Width           int // intrinsic size of the upright image
+	Height          int
+	Preview         asset.Preview
+	SrcImages       []asset.SrcImage
+	Dir             string // path of the image's collection, when listed on a page outside it
+	Position        *Position
+}
+
+// PlaceholderStyle returns the inline style that shows the image's dominant color and blurred placeholder until the
+// image itself loads over them
+func (p PrintInfo) PlaceholderStyle() template.CSS {
+	if p.Preview.Color == "" {
+		return ""
+	}
+	style := "background-color: " + p.Preview.Color + ";"
+	if p.Preview.Placeholder != "" {
+		style += " background-image: url(" + p.Preview.Placeholder + ");"
+	}
+	return template.CSS(style)
+}
+
+// AltText returns the text alternative to the image, which is its title unless its sidecar gives one
+func (p PrintInfo) AltText() string {
+	if p.Alt != "" || p.Untitled {
+		return p.Alt
+	}
+	return p.Title
+}
+
+// Fallback returns the JPEG renditions of the image, for the img element every browser can show
+func (p PrintInfo) Fallback() []asset.SrcImage {
+	fallback, _ := asset.Group(p.SrcImages, false)
+	return fallback
+}
+
+// Src returns the name of the full size JPEG rendition of the image
+func (p PrintInfo) Src() string {
+	if fallback := p.Fallback(); len(fallback) > 0 {
+		return fallback[0].Name
+	}
+	return ""
+}
+
+// Sources returns the renditions of the image in each additional format, for picture source elements
+func (p PrintInfo) Sources() []asset.SrcSet {
+	_, sources := asset.Group(p.SrcImages, false)
+	return sources
+}
+
+// Grid returns the image as shown in cover and gallery grids, which is its fixed-aspect crop if it has one
+func (p PrintInfo) Grid() PrintInfo {
+	crop, _ := asset.Group(p.SrcImages, true)
+	if len(crop) == 0 {
+		return p
+	}
+	grid := p
+	grid.SrcImages = nil
+	for _, src := range p.SrcImages {
+		if src.Crop {
+			src.Crop = false
+			grid.SrcImages = append(grid.SrcImages, src)
+		}
+	}
+	grid.Width, grid.Height = crop[0].Bounds.Dx(), crop[0].Bounds.Dy()
+	return grid
 }
 
 type NavInfo struct {
@@ -49,51 +127,76 @@ type PageInfo struct {
 	PrintInfo
 }
 
-type Ordered []PrintInfo
-
-func (o Ordered) Len() int      { return len(o) }
-func (o Ordered) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
-func (o Ordered) Less(i, j int) bool {
-	if o[j].Order == 0 && o[j].Order == 0 {
-		return o[i].Date.Before(o[j].Date)
-	}
-	if o[j].Order == 0 {
-		return true
-	}
-	if o[i].Order == 0 {
-		return false
-	}
-	return o[i].Order < o[j].Order
-}
-
-func getInfo(filename string, r io.Reader) (info PrintInfo) {
+// getInfo reads what is known about an image from its file name, its EXIF metadata from r and its other captions,
+// keyed by source, which are merged with the EXIF caption in order of precedence
+func getInfo(filename string, r io.Reader, captions map[string]Caption) (info PrintInfo) {
 	info.Filename = filename
 	title, order, cover, untitled := asset.FileInfo(filename)
 	info.Title = stripExtension(title)
 	info.Untitled = untitled
+	info.Slug = site.LowerDash(info.Title)
 	info.RelURL = site.Escape(info.Title)
 	info.FileURL = site.Escape(info.Filename)
 	info.Order = order
 	info.Cover = cover
 
+	captions[exifSource] = readExif(&info, r)
+	caption := mergeCaptions(captions)
+	if caption.Title != "" {
+		info.Title = caption.Title
+		info.Untitled = false
+	}
+	info.Description = caption.Description
+	info.DescriptionHTML = template.HTML(template.HTMLEscapeString(info.Description))
+	info.Copyright = caption.Copyright
+	if info.Copyright == "" {
+		info.Copyright = viper.GetString("copyright")
+	}
+	info.Keywords = caption.Keywords
+	info.Rating = caption.Rating
+	info.Label = caption.Label
+	info.Location = caption.Location
+	info.Byline = caption.Byline
+	info.Credit = caption.Credit
+	return
+}
+
+// readExif fills in the camera details of info from the EXIF metadata read from r, returning its descriptive metadata
+func readExif(info *PrintInfo, r io.Reader) (c Caption) {
 	if r == nil {
 		return
 	}
 	x, err := exif.Decode(r)
-	if err != nil {
-		log.Error(filename)
-		log.Fatal(err)
+	if x == nil { // a missing or unreadable EXIF block just means there's no metadata to show
+		if err != nil && err != io.EOF {
+			log.Infof("%s: no EXIF metadata (%s)", info.Filename, err.Error())
+		}
+		return
 	}
 	info.IncludesExif = true
 	zoom := false
 	if tag, err := x.Get(exif.Copyright); err == nil && tag.String() != "" {
-		info.Copyright = strings.Trim(tag.String(), "\"")
-	} else {
-		info.Copyright = viper.GetString("copyright")
+		c.Copyright = strings.Trim(tag.String(), "\"")
+	}
+
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if orientation, err := tag.Int(0); err == nil {
+			info.Orientation = orientation
+		}
 	}
 
 	if tag, err := x.Get(exif.ImageDescription); err == nil && tag.String() != "" {
-		info.Description = strings.Trim(tag.String(), "\"")
+		c.Description = strings.Trim(tag.String(), "\"")
+	}
+
+	if tag, err := x.Get(exif.Artist); err == nil && tag.String() != "" {
+		c.Byline = strings.Trim(tag.String(), "\"")
+	}
+
+	info.Position = readPosition(x)
+
+	if tag, err := x.Get(exif.XPKeywords); err == nil {
+		c.Keywords = xpKeywords(tag.Val)
 	}
 
 	if tag, err := x.Get(exif.DateTimeOriginal); err == nil && tag.String() != "" {
@@ -198,3 +301,18 @@ func extension(in string) string {
 	}
 	return in
 }
+
+// xpKeywords reads the semicolon separated keywords Windows writes to the EXIF XPKeywords tag as UTF-16
+func xpKeywords(b []byte) (keywords []string) {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	text := strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	for _, keyword := range strings.Split(text, ";") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return
+}
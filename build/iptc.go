@@ -0,0 +1,95 @@
+package build
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode/utf8"
+)
+
+// IPTC-IIM datasets read, all from the application record
+const (
+	iptcRecordEnvelope    = 1
+	iptcRecordApplication = 2
+
+	iptcCharset     = 90 // envelope record coded character set
+	iptcObjectName  = 5
+	iptcKeywords    = 25
+	iptcByline      = 80
+	iptcCity        = 90
+	iptcSublocation = 92
+	iptcState       = 95
+	iptcCountry     = 101
+	iptcCredit      = 110
+	iptcCopyright   = 116
+	iptcCaption     = 120
+)
+
+// utf8Charset is the escape sequence the coded character set dataset uses to declare UTF-8
+var utf8Charset = []byte("\x1b%G")
+
+// parseIPTC reads the descriptive metadata of IPTC-IIM records. Each record is a 0x1c tag marker, record and dataset
+// numbers and a two byte length, followed by the data. Text is UTF-8 if declared so or valid as such, and otherwise
+// taken to be Latin-1. Reading stops at a truncated record, keeping those before it.
+func parseIPTC(records []byte) (c Caption) {
+	var (
+		values  = map[int][]string{}
+		charset []byte
+	)
+	for len(records) >= 5 && records[0] == 0x1c {
+		record, dataset := records[1], int(records[2])
+		length := int(binary.BigEndian.Uint16(records[3:5]))
+		if length&0x8000 != 0 { // extended datasets are never text, so just skip them
+			n := length & 0x7fff
+			if n > 4 || 5+n > len(records) {
+				break
+			}
+			length = 0
+			for _, b := range records[5 : 5+n] {
+				length = length<<8 | int(b)
+			}
+			records = records[5+n:]
+		} else {
+			records = records[5:]
+		}
+		if length > len(records) {
+			break
+		}
+		data := records[:length]
+		records = records[length:]
+		switch record {
+		case iptcRecordEnvelope:
+			if dataset == iptcCharset {
+				charset = data
+			}
+		case iptcRecordApplication:
+			values[dataset] = append(values[dataset], iptcText(data, charset))
+		}
+	}
+	first := func(dataset int) string {
+		if len(values[dataset]) > 0 {
+			return values[dataset][0]
+		}
+		return ""
+	}
+	c.Title = first(iptcObjectName)
+	c.Description = first(iptcCaption)
+	c.Copyright = first(iptcCopyright)
+	c.Keywords = values[iptcKeywords]
+	c.Byline = first(iptcByline)
+	c.Credit = first(iptcCredit)
+	c.Location = joinLocation(first(iptcSublocation), first(iptcCity), first(iptcState), first(iptcCountry))
+	return
+}
+
+// iptcText decodes the text of a dataset in the given coded character set
+func iptcText(data, charset []byte) string {
+	if bytes.Equal(charset, utf8Charset) || utf8.Valid(data) {
+		return strings.TrimSpace(string(data))
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b) // Latin-1 maps directly onto the first 256 code points
+	}
+	return strings.TrimSpace(string(runes))
+}
@@ -0,0 +1,86 @@
+package build
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// iim returns an IPTC-IIM record holding the data
+func iim(record, dataset byte, data string) []byte {
+	return append(binary.BigEndian.AppendUint16([]byte{0x1c, record, dataset}, uint16(len(data))), data...)
+}
+
+// iimBlock concatenates IPTC-IIM records
+func iimBlock(records ...[]byte) (out []byte) {
+	for _, r := range records {
+		out = append(out, r...)
+	}
+	return
+}
+
+func TestParseIPTC(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []byte
+		want    Caption
+	}{
+		{"empty", nil, Caption{}},
+		{"application", iimBlock(
+			iim(2, iptcObjectName, "Harbour"),
+			iim(2, iptcCaption, " Boats at dawn "),
+			iim(2, iptcKeywords, "boats"),
+			iim(2, iptcKeywords, "sea"),
+			iim(2, iptcByline, "Jo Bloggs"),
+			iim(2, iptcCredit, "Agency"),
+			iim(2, iptcCopyright, "© Jo"),
+			iim(2, iptcSublocation, "Port"),
+			iim(2, iptcCity, "Oban"),
+			iim(2, iptcCountry, "Scotland"),
+		), Caption{Title: "Harbour", Description: "Boats at dawn", Copyright: "© Jo", Keywords: []string{"boats", "sea"},
+			Location: "Port, Oban, Scotland", Byline: "Jo Bloggs", Credit: "Agency"}},
+		{"latin-1", iim(2, iptcCaption, "Caf\xe9"), Caption{Description: "Café"}},
+		{"declared utf-8", iimBlock(iim(1, iptcCharset, "\x1b%G"), iim(2, iptcCaption, "Café")),
+			Caption{Description: "Café"}},
+		{"envelope only", iim(1, iptcObjectName, "Not a title"), Caption{}},
+		{"extended dataset", iimBlock(
+			[]byte{0x1c, 2, 202, 0x80, 0x02, 0x00, 0x03}, []byte("bin"),
+			iim(2, iptcObjectName, "After"),
+		), Caption{Title: "After"}},
+		{"truncated", iimBlock(iim(2, iptcObjectName, "Whole"), iim(2, iptcCaption, "Cut short")[:9]),
+			Caption{Title: "Whole"}},
+	}
+	for _, test := range tests {
+		if got := parseIPTC(test.records); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMergeCaptions(t *testing.T) {
+	defer viper.Set(METADATA_PRECEDENCE, viper.Get(METADATA_PRECEDENCE))
+	captions := map[string]Caption{
+		exifSource: {Title: "exif", Copyright: "exif"},
+		xmpSource:  {Title: "xmp", Description: "xmp", Rating: 3},
+		iptcSource: {Title: "iptc", Description: "iptc", Copyright: "iptc", Byline: "iptc"},
+	}
+	tests := []struct {
+		precedence []string
+		want       Caption
+	}{
+		{nil, Caption{Title: "exif", Description: "xmp", Copyright: "exif", Rating: 3, Byline: "iptc"}},
+		{[]string{"iptc", "xmp", "exif"}, Caption{Title: "iptc", Description: "iptc", Copyright: "iptc", Rating: 3,
+			Byline: "iptc"}},
+		// sources left out follow in their default order, and unknown ones are ignored
+		{[]string{"XMP", "unknown"}, Caption{Title: "xmp", Description: "xmp", Copyright: "exif", Rating: 3,
+			Byline: "iptc"}},
+	}
+	for _, test := range tests {
+		viper.Set(METADATA_PRECEDENCE, test.precedence)
+		if got := mergeCaptions(captions); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("precedence %v: got %+v, want %+v", test.precedence, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package build
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gpitfield/filmstrip/asset"
+	"github.com/gpitfield/filmstrip/site"
+	log "github.com/gpitfield/relog"
+)
+
+const manifestVersion = 1
+
+// Manifest records what each source image looked like when it was last cut, and what it was cut into,
+// so that unchanged images are neither re-read nor re-cut on the next build
+type Manifest struct {
+	Version     int                     `json:"version"`
+	Sources     map[string]*SourceEntry `json:"sources"`     // keyed by image path relative to the source location
+	Collections map[string]*PrintInfo   `json:"collections"` // cover of each collection, keyed by source path
+	Images      map[string]*PrintInfo   `json:"images"`      // each published image, keyed by source path
+	Pages       []string                `json:"pages"`       // generated HTML and other non-image paths relative to the public site
+	written     []string                // paths actually (re)written by the build in progress
+	mu          sync.Mutex
+}
+
+// SourceEntry is the state of a single source image as of its last build
+type SourceEntry struct {
+	Hash        string        `json:"hash"`
+	Size        int64         `json:"size"`
+	ModTime     time.Time     `json:"mtime"`
+	Fingerprint string        `json:"fingerprint"` // asset.Fingerprint of the config the renditions were cut with
+	Renditions  []string      `json:"renditions"`  // generated image paths relative to the public site
+	Preview     asset.Preview `json:"preview"`
+	Published   time.Time     `json:"published"` // when the image was first published, which its feed entries are dated by
+}
+
+func newManifest() *Manifest {
+	return &Manifest{Version: manifestVersion, Sources: map[string]*SourceEntry{}, Collections: map[string]*PrintInfo{},
+		Images: map[string]*PrintInfo{}}
+}
+
+// loadManifest reads the manifest of the previous build, returning an empty manifest if there isn't a usable one
+func loadManifest() *Manifest {
+	m := newManifest()
+	b, err := ioutil.ReadFile(site.PubSiteDir + "/" + site.ManifestFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error(err)
+		}
+		return m
+	}
+	if err = json.Unmarshal(b, m); err != nil {
+		log.Errorf("ignoring unreadable build manifest: %s", err.Error())
+		return newManifest()
+	}
+	if m.Version != manifestVersion || m.Sources == nil {
+		return newManifest()
+	}
+	if m.Collections == nil {
+		m.Collections = map[string]*PrintInfo{}
+	}
+	if m.Images == nil {
+		m.Images = map[string]*PrintInfo{}
+	}
+	return m
+}
+
+// save writes the manifest to the public site directory
+func (m *Manifest) save() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	err = ioutil.WriteFile(site.PubSiteDir+"/"+site.ManifestFile, b, 0644)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// Source returns the entry for the given source path, or nil if there isn't one
+func (m *Manifest) Source(path string) *SourceEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Sources[path]
+}
+
+// SetSource records the entry for the given source path
+func (m *Manifest) SetSource(path string, entry *SourceEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Sources[path] = entry
+}
+
+// AddPage records a generated non-image file
+func (m *Manifest) AddPage(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Pages = append(m.Pages, path)
+	m.written = append(m.written, path)
+}
+
+// AddWritten records files written by the build in progress other than pages, such as newly cut images
+func (m *Manifest) AddWritten(paths ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.written = append(m.written, paths...)
+}
+
+// Written returns the paths written by the build in progress, relative to the public site
+func (m *Manifest) Written() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string{}, m.written...)
+}
+
+// Collection returns the cover of the collection at the given source path, or nil if there isn't one
+func (m *Manifest) Collection(inPath string) *PrintInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Collections[inPath]
+}
+
+// SetCollection records the cover of the collection at the given source path
+func (m *Manifest) SetCollection(inPath string, cover PrintInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Collections[inPath] = &cover
+}
+
+// CollectionTitles returns the title of each collection, keyed by source path
+func (m *Manifest) CollectionTitles() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	titles := map[string]string{}
+	for inPath, cover := range m.Collections {
+		titles[inPath] = cover.Title
+	}
+	return titles
+}
+
+// SetImage records the image published from the given source path, so that pages listing images from across the site
+// can include it without its collection being rebuilt
+func (m *Manifest) SetImage(srcPath string, info PrintInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Images[srcPath] = &info
+}
+
+// images returns every image published by the build, including hidden ones, keyed by source path
+func (m *Manifest) images() map[string]PrintInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	images := map[string]PrintInfo{}
+	for path, info := range m.Images {
+		images[path] = *info
+	}
+	return images
+}
+
+// PagePaths returns the generated HTML and other non-image paths, relative to the public site
+func (m *Manifest) PagePaths() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string{}, m.Pages...)
+}
+
+// Published returns every image published by the build that isn't hidden, in source path order
+func (m *Manifest) Published() (images []PrintInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	paths := make([]string, 0, len(m.Images))
+	for path := range m.Images {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if info := m.Images[path]; !info.Hidden {
+			images = append(images, *info)
+		}
+	}
+	return
+}
+
+// carryOver copies the sources, collections, images and pages of the collection at inPath, and everything beneath it,
+// from the last build without rebuilding them
+func (m *Manifest) carryOver(last *Manifest, inPath, outPath string) {
+	last.mu.Lock()
+	defer last.mu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for path, entry := range last.Sources {
+		if strings.HasPrefix(path, inPath+"/") {
+			m.Sources[path] = entry
+		}
+	}
+	for path, cover := range last.Collections {
+		if path == inPath || strings.HasPrefix(path, inPath+"/") {
+			m.Collections[path] = cover
+		}
+	}
+	for path, info := range last.Images {
+		if strings.HasPrefix(path, inPath+"/") {
+			m.Images[path] = info
+		}
+	}
+	for _, path := range last.Pages {
+		if strings.HasPrefix(path, outPath+"/") {
+			m.Pages = append(m.Pages, path)
+		}
+	}
+}
+
+// Outputs returns the set of every path the build generated in the public site, including the manifest itself
+func (m *Manifest) Outputs() map[string]bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	outputs := map[string]bool{"/" + site.ManifestFile: true}
+	for _, entry := range m.Sources {
+		for _, path := range entry.Renditions {
+			outputs[path] = true
+		}
+	}
+	for _, path := range m.Pages {
+		outputs[path] = true
+	}
+	return outputs
+}
+
+// unchanged reports whether the entry matches the given file on disk without reading its contents
+func (e *SourceEntry) unchanged(stat os.FileInfo) bool {
+	return e.Size == stat.Size() && e.ModTime.Equal(stat.ModTime())
+}
+
+// renditionsExist reports whether every generated image of the entry is still in the public site, in the given images
+// directory, which moves if its collection's slug changes
+func (e *SourceEntry) renditionsExist(imagesPath string) bool {
+	if len(e.Renditions) == 0 {
+		return false
+	}
+	for _, path := range e.Renditions {
+		if !strings.HasPrefix(path, imagesPath+"/") {
+			return false
+		}
+		if _, err := os.Stat(site.PubSiteDir + path); err != nil {
+			return false
+		}
+	}
+	return true
+}
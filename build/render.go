@@ -2,6 +2,7 @@ package build
 
 import (
 	"bytes"
+	"fmt"
 	"html/template"
 
 	// log "github.com/gpitfield/relog"
@@ -27,39 +28,51 @@ func renderAbout() *bytes.Buffer {
 
 }
 
-func renderDetail(collectionName string, info PrintInfo, collectionInfo []PrintInfo) *bytes.Buffer {
+func renderDetail(collectionName string, info PrintInfo, collectionInfo []PrintInfo, tags []NavInfo, mapLink string) *bytes.Buffer {
 	details := make(map[string]interface{})
 	details["Title"] = viper.GetString("site-title")
 	details["Collection"] = collectionName
-	if len(collectionInfo) > 1 {
-		var matchIndex = 0
-		for i, _ := range collectionInfo {
-			if collectionInfo[i].Filename == info.Filename {
-				matchIndex = i
-				break
-			}
+	// images left out of the gallery, and those alone in it, have no previous or next image to link to
+	for i := range collectionInfo {
+		if collectionInfo[i].Filename == info.Filename && len(collectionInfo) > 1 {
+			details["Previous"] = collectionInfo[(i+len(collectionInfo)-1)%len(collectionInfo)]
+			details["Next"] = collectionInfo[(i+1)%len(collectionInfo)]
+			break
 		}
-		if matchIndex > 0 {
-			details["Previous"] = collectionInfo[matchIndex-1]
-		} else {
-			details["Previous"] = collectionInfo[len(collectionInfo)-1]
-		}
-		if matchIndex < len(collectionInfo)-1 {
-			details["Next"] = collectionInfo[matchIndex+1]
-		} else {
-			details["Next"] = collectionInfo[0]
-		}
-	} else {
-		details["Next"] = 0
-		details["Previous"] = 0
 	}
 	details["Image"] = info
+	details["Tags"] = tags
+	details["MapLink"] = mapLink
+	details["Sizes"] = "100vw"
 	buf := new(bytes.Buffer)
 	site.Templates.ExecuteTemplate(buf, "detail.html", details)
 	return buf
 }
 
-func renderGallery(collectionName string, images []PrintInfo, cover bool) *bytes.Buffer {
+func renderGallery(collectionName string, meta CollectionInfo, images []PrintInfo, cover bool) *bytes.Buffer {
+	gallery := galleryValues(collectionName, meta, images, cover)
+	buf := new(bytes.Buffer)
+	if cover {
+		site.Templates.ExecuteTemplate(buf, "cover.html", gallery)
+	} else {
+		site.Templates.ExecuteTemplate(buf, "gallery.html", gallery)
+	}
+	return buf
+}
+
+// renderPeriod renders the archive gallery of a year or month, linked to the periods before and after it, if any
+func renderPeriod(period, previous, next *Period) *bytes.Buffer {
+	gallery := galleryValues(period.Name, CollectionInfo{}, period.Images, false)
+	gallery["Archive"] = "/" + site.ArchiveDir + "/index.html"
+	gallery["PrevPeriod"] = previous
+	gallery["NextPeriod"] = next
+	buf := new(bytes.Buffer)
+	site.Templates.ExecuteTemplate(buf, "gallery.html", gallery)
+	return buf
+}
+
+// galleryValues returns what the gallery and cover templates are rendered with
+func galleryValues(collectionName string, meta CollectionInfo, images []PrintInfo, cover bool) map[string]interface{} {
 	gallery := make(map[string]interface{})
 	gallery["Gallery"] = true
 	if collectionName == "" {
@@ -71,11 +84,66 @@ func renderGallery(collectionName string, images []PrintInfo, cover bool) *bytes
 	gallery["Title"] = viper.GetString("site-title")
 	gallery["Copyright"] = viper.GetString("copyright")
 	gallery["Images"] = images
-	buf := new(bytes.Buffer)
-	if cover {
-		site.Templates.ExecuteTemplate(buf, "cover.html", gallery)
-	} else {
-		site.Templates.ExecuteTemplate(buf, "gallery.html", gallery)
+	gallery["Description"] = meta.DescriptionHTML()
+	gallery["Dates"] = meta.DateRange()
+	gallery["Columns"] = meta.Columns
+	columns := meta.Columns
+	if columns == 0 && cover {
+		columns = viper.GetInt("cover-columns")
+	} else if columns == 0 {
+		columns = viper.GetInt("gallery-columns")
 	}
+	gallery["Sizes"] = columnSizes(columns)
+	return gallery
+}
+
+// renderTags renders the index of tags, each linked to its gallery
+func renderTags(tags []*Tag) *bytes.Buffer {
+	index := make(map[string]interface{})
+	index["Gallery"] = true
+	index["Collection"] = "Tags"
+	index["Title"] = viper.GetString("site-title")
+	index["Copyright"] = viper.GetString("copyright")
+	index["TagIndex"] = tags
+	buf := new(bytes.Buffer)
+	site.Templates.ExecuteTemplate(buf, "tags.html", index)
 	return buf
 }
+
+// renderArchive renders the index of the archive, listing the number of images of each year and month
+func renderArchive(years []*Period) *bytes.Buffer {
+	index := make(map[string]interface{})
+	index["Gallery"] = true
+	index["Collection"] = "Archive"
+	index["Title"] = viper.GetString("site-title")
+	index["Copyright"] = viper.GetString("copyright")
+	index["Years"] = years
+	buf := new(bytes.Buffer)
+	site.Templates.ExecuteTemplate(buf, "archive.html", index)
+	return buf
+}
+
+// renderMap renders the map page, which draws a marker for each image in the site's GeoJSON
+func renderMap() *bytes.Buffer {
+	page := make(map[string]interface{})
+	page["Gallery"] = true
+	page["Collection"] = "Map"
+	page["Title"] = viper.GetString("site-title")
+	page["Copyright"] = viper.GetString("copyright")
+	page["Tiles"] = viper.GetString(MAP_TILES)
+	page["Attribution"] = viper.GetString(MAP_ATTRIBUTION)
+	page["GeoJSON"] = "/" + geoJSONFile
+	page["Zoom"] = mapZoom
+	buf := new(bytes.Buffer)
+	site.Templates.ExecuteTemplate(buf, "map.html", page)
+	return buf
+}
+
+// columnSizes returns the sizes attribute of images laid out in the given number of columns, so that browsers choose
+// the rendition closest to the width each image is actually shown at
+func columnSizes(columns int) string {
+	if columns <= 1 {
+		return "100vw"
+	}
+	return fmt.Sprintf("%.4gvw", 100/float64(columns))
+}
@@ -0,0 +1,197 @@
+package build
+
+import (
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gpitfield/filmstrip/asset"
+	log "github.com/gpitfield/relog"
+	"github.com/russross/blackfriday"
+	"gopkg.in/yaml.v2"
+)
+
+// collectionSidecar is the file in a collection's source directory holding the sidecars of its images, keyed by file
+// name
+const collectionSidecar = "images.yml"
+
+// sidecarExtensions are those a sidecar may have, which as YAML is a superset of JSON are all read as YAML
+var sidecarExtensions = []string{".yml", ".yaml", ".json"}
+
+// dateFormats are the layouts a sidecar date may be written in
+var dateFormats = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// Sidecar is the optional YAML or JSON file alongside a source image, named for the image with .yml or .json
+// appended, e.g. photo.jpg.yml, that overrides what is otherwise read from the image and its file name. Sidecars may
+// also be listed in a collection's images.yml, which the image's own sidecar takes precedence over.
+type Sidecar struct {
+	Title        string       `yaml:"title"`
+	Slug         string       `yaml:"slug"`        // name of the image's page, in place of the file name
+	Description  string       `yaml:"description"` // Markdown
+	Date         string       `yaml:"date"`
+	Order        *int         `yaml:"order"`
+	Cover        *bool        `yaml:"cover"`
+	Hidden       *bool        `yaml:"hidden"` // left out of the gallery, though its page is still published
+	Alt          string       `yaml:"alt"`
+	Keywords     []string     `yaml:"keywords"`      // in place of those in the image's metadata
+	HideLocation *bool        `yaml:"hide-location"` // keep where the image was taken off the map
+	Focus        *asset.Focus `yaml:"focus"`         // point its crops are centered on, as fractions of its width and height
+	Watermark    *bool        `yaml:"watermark"`     // false to publish the image without the watermark
+}
+
+// readSidecar reads the sidecar of the source image at fullPath, returning an empty one if there is none
+func readSidecar(fullPath string) (sidecar Sidecar) {
+	for _, ext := range sidecarExtensions {
+		if readYAML(fullPath+ext, &sidecar) {
+			return
+		}
+	}
+	return
+}
+
+// readCollectionSidecars reads the images.yml of the collection at the given source path, keyed by file name
+func readCollectionSidecars(inPath string) (sidecars map[string]Sidecar) {
+	readYAML(sourceLocation+inPath+"/"+collectionSidecar, &sidecars)
+	return
+}
+
+// lookupSidecar returns the sidecar listed for filename in a collection's images.yml, under either its file name
+// or its file name without any ordering prefix
+func lookupSidecar(sidecars map[string]Sidecar, filename string) Sidecar {
+	if sidecar, ok := sidecars[filename]; ok {
+		return sidecar
+	}
+	name, _, _, _ := asset.FileInfo(filename)
+	return sidecars[name]
+}
+
+// readYAML reads the YAML file at path into v, reporting whether there was a file to read
+func readYAML(path string, v interface{}) bool {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error(err)
+		}
+		return false
+	}
+	if err = yaml.Unmarshal(b, v); err != nil {
+		log.Errorf("ignoring unreadable %s: %s", path, err.Error())
+	}
+	return true
+}
+
+// merge returns the sidecar with any field it leaves unset taken from other
+func (s Sidecar) merge(other Sidecar) Sidecar {
+	if s.Title == "" {
+		s.Title = other.Title
+	}
+	if s.Slug == "" {
+		s.Slug = other.Slug
+	}
+	if s.Description == "" {
+		s.Description = other.Description
+	}
+	if s.Date == "" {
+		s.Date = other.Date
+	}
+	if s.Order == nil {
+		s.Order = other.Order
+	}
+	if s.Cover == nil {
+		s.Cover = other.Cover
+	}
+	if s.Hidden == nil {
+		s.Hidden = other.Hidden
+	}
+	if s.Alt == "" {
+		s.Alt = other.Alt
+	}
+	if len(s.Keywords) == 0 {
+		s.Keywords = other.Keywords
+	}
+	if s.HideLocation == nil {
+		s.HideLocation = other.HideLocation
+	}
+	if s.Focus == nil {
+		s.Focus = other.Focus
+	}
+	if s.Watermark == nil {
+		s.Watermark = other.Watermark
+	}
+	return s
+}
+
+// apply overrides info with what the sidecar sets
+func (s Sidecar) apply(info *PrintInfo) {
+	if s.Title != "" {
+		info.Title = s.Title
+		info.Untitled = false
+	}
+	if slug := safeSlug(s.Slug); slug != "" {
+		info.Slug = slug
+		info.RelURL = slug
+	}
+	if s.Description != "" {
+		info.Description = s.Description
+		info.DescriptionHTML = template.HTML(blackfriday.MarkdownCommon([]byte(s.Description)))
+	}
+	if s.Date != "" {
+		if date, ok := parseDate(s.Date); ok {
+			info.Date = date
+			info.DateString = date.Format("January, 2006")
+		} else {
+			log.Errorf("%s: unreadable date %s", info.Filename, s.Date)
+		}
+	}
+	if s.Order != nil {
+		info.Order = *s.Order
+	}
+	if s.Cover != nil {
+		info.Cover = *s.Cover
+	}
+	if s.Hidden != nil {
+		info.Hidden = *s.Hidden
+	}
+	if s.Alt != "" {
+		info.Alt = s.Alt
+	}
+	if len(s.Keywords) > 0 {
+		info.Keywords = s.Keywords
+	}
+	if s.HideLocation != nil && *s.HideLocation {
+		info.Position = nil
+	}
+}
+
+// safeSlug returns a slug reduced to a single path segment, its ASCII letters and digits in lower case with anything
+// else between them replaced by a dash, or "" if it has none
+func safeSlug(slug string) string {
+	words := strings.FieldsFunc(strings.ToLower(slug), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	return strings.Join(words, "-")
+}
+
+// parseDate reads a date in any of the sidecar date formats
+func parseDate(value string) (time.Time, bool) {
+	for _, format := range dateFormats {
+		if date, err := time.Parse(format, strings.TrimSpace(value)); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// isSidecar reports whether the file is a sidecar or other metadata file, rather than something to publish
+func isSidecar(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, sidecar := range sidecarExtensions {
+		if ext == sidecar {
+			return true
+		}
+	}
+	return ext == xmpExtension
+}
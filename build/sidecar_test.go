@@ -0,0 +1,28 @@
+package build
+
+import "testing"
+
+func TestSidecarSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		want string
+	}{
+		{"", "tree"},
+		{"lone-tree", "lone-tree"},
+		{"Lone Tree", "lone-tree"},
+		{"a/b", "a-b"},
+		{"../../x", "x"},
+		{"/etc/passwd", "etc-passwd"},
+		{"..", "tree"},
+		{"../..", "tree"},
+		{"Þingvellir", "ingvellir"},
+		{"é", "tree"},
+	}
+	for _, test := range tests {
+		info := PrintInfo{Slug: "tree", RelURL: "tree"}
+		Sidecar{Slug: test.slug}.apply(&info)
+		if info.Slug != test.want || info.RelURL != test.want {
+			t.Errorf("slug %q: got page %q linked as %q, want %q", test.slug, info.Slug, info.RelURL, test.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package build
+
+import (
+	"encoding/xml"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gpitfield/filmstrip/site"
+	"github.com/spf13/viper"
+)
+
+const (
+	ROBOTS_DISALLOW = "robots.disallow" // paths robots.txt asks crawlers to stay out of
+	ROBOTS_TEXT     = "robots.text"     // the whole of robots.txt, in place of the one generated
+
+	sitemapFile = "sitemap.xml"
+	robotsFile  = "robots.txt"
+)
+
+// sitemap and sitemapURL are a sitemap of pages, with the images shown on each
+type sitemap struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	ImageNS string       `xml:"xmlns:image,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string         `xml:"loc"`
+	LastMod string         `xml:"lastmod,omitempty"`
+	Images  []sitemapImage `xml:"image:image"`
+}
+
+type sitemapImage struct {
+	Loc string `xml:"image:loc"`
+}
+
+// buildSitemap publishes a sitemap of every page of the site, other than those of hidden images and collections,
+// listing the images of each detail page, if base-url is set. It then publishes robots.txt, pointing crawlers to the
+// sitemap.
+func buildSitemap() {
+	base := baseURL()
+	if base != "" {
+		writeXML("/"+sitemapFile, buildURLs(base))
+	}
+	writePage("/"+robotsFile, []byte(robots(base)))
+}
+
+// buildURLs returns the sitemap of the pages the build published, dating each detail page by when its source image
+// was last modified, and each gallery by the most recent of its images
+func buildURLs(base string) sitemap {
+	var hidden []string // public site paths of hidden collections
+	for inPath := range manifest.CollectionTitles() {
+		if inPath != "" && collectionHidden(inPath) {
+			hidden = append(hidden, collectionPath(inPath)+"/")
+		}
+	}
+	details := map[string]PrintInfo{}
+	modified := map[string]time.Time{}
+	for srcPath, info := range manifest.images() {
+		page := path.Dir(info.AbsURL) + "/" + info.Slug + ".html"
+		details[page] = info
+		if info.Hidden {
+			continue
+		}
+		if entry := manifest.Source(srcPath); entry != nil {
+			modified[page] = entry.ModTime
+			for dir := path.Dir(page); ; dir = path.Dir(dir) {
+				if index := strings.TrimSuffix(dir, "/") + "/index.html"; entry.ModTime.After(modified[index]) {
+					modified[index] = entry.ModTime
+				}
+				if dir == "/" {
+					break
+				}
+			}
+		}
+	}
+
+	doc := sitemap{ImageNS: "http://www.google.com/schemas/sitemap-image/1.1"}
+	pages := manifest.PagePaths()
+	sort.Strings(pages)
+	for _, page := range pages {
+		if !strings.HasSuffix(page, ".html") || listedUnder(page, hidden) {
+			continue
+		}
+		info, detail := details[page]
+		if detail && info.Hidden {
+			continue
+		}
+		entry := sitemapURL{Loc: base + escapePath(strings.TrimSuffix(page, "index.html"))}
+		if !modified[page].IsZero() {
+			entry.LastMod = modified[page].UTC().Format(time.RFC3339)
+		}
+		if detail {
+			dir := path.Dir(info.AbsURL) + "/" + site.ImagesDir + "/"
+			for _, src := range info.SrcImages {
+				entry.Images = append(entry.Images, sitemapImage{Loc: base + escapePath(dir+src.Name)})
+			}
+		}
+		doc.URLs = append(doc.URLs, entry)
+	}
+	return doc
+}
+
+// escapePath percent-encodes a public site path for use in a URL
+func escapePath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// listedUnder reports whether the page is under any of the given directories
+func listedUnder(page string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(page, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// robots returns the text of robots.txt: robots.text if set, or else a rule for every crawler disallowing the paths in
+// robots.disallow, and the address of the sitemap if there is one
+func robots(base string) string {
+	if text := viper.GetString(ROBOTS_TEXT); text != "" {
+		return text
+	}
+	lines := []string{"User-agent: *"}
+	disallow := viper.GetStringSlice(ROBOTS_DISALLOW)
+	for _, path := range disallow {
+		lines = append(lines, "Disallow: "+path)
+	}
+	if len(disallow) == 0 {
+		lines = append(lines, "Disallow:")
+	}
+	if base != "" {
+		lines = append(lines, "", "Sitemap: "+base+"/"+sitemapFile)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
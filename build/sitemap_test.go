@@ -0,0 +1,110 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gpitfield/filmstrip/asset"
+	"github.com/gpitfield/filmstrip/site"
+	"github.com/spf13/viper"
+)
+
+// goldenSitemap is the sitemap of the site of TestBuildSitemap, which leaves out hidden images and collections
+const goldenSitemap = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:image="http://www.google.com/schemas/sitemap-image/1.1">
+  <url>
+    <loc>https://example.com/</loc>
+    <lastmod>2024-05-03T12:00:00Z</lastmod>
+  </url>
+  <url>
+    <loc>https://example.com/travel/caf%C3%A9.html</loc>
+    <lastmod>2024-05-01T12:00:00Z</lastmod>
+    <image:image>
+      <image:loc>https://example.com/travel/images/caf%C3%A9-400.jpg</image:loc>
+    </image:image>
+  </url>
+  <url>
+    <loc>https://example.com/travel/</loc>
+    <lastmod>2024-05-03T12:00:00Z</lastmod>
+  </url>
+  <url>
+    <loc>https://example.com/travel/tram.html</loc>
+    <lastmod>2024-05-03T12:00:00Z</lastmod>
+    <image:image>
+      <image:loc>https://example.com/travel/images/tram-400.jpg</image:loc>
+    </image:image>
+    <image:image>
+      <image:loc>https://example.com/travel/images/tram-400.webp</image:loc>
+    </image:image>
+  </url>
+</urlset>`
+
+func TestBuildSitemap(t *testing.T) {
+	inSite(t, map[string]string{"Private/collection.yml": "hidden: true\n"})
+	defer viper.Set(BASE_URL, viper.Get(BASE_URL))
+	viper.Set(BASE_URL, "https://example.com")
+	if err := os.MkdirAll(site.PubSiteDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	resetCollections()
+	manifest = newManifest()
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 12, 0, 0, 0, time.UTC) }
+	for inPath, title := range map[string]string{"": "Home", "/Travel": "Travel", "/Private": "Private"} {
+		manifest.SetCollection(inPath, PrintInfo{Title: title})
+	}
+	images := map[string]PrintInfo{
+		"/Travel/tram.jpg": {Slug: "tram", AbsURL: "/travel/tram.jpg",
+			SrcImages: []asset.SrcImage{{Name: "tram-400.jpg"}, {Name: "tram-400.webp"}}},
+		"/Travel/café.jpg":    {Slug: "café", AbsURL: "/travel/café.jpg", SrcImages: []asset.SrcImage{{Name: "café-400.jpg"}}},
+		"/Travel/draft.jpg":   {Slug: "draft", AbsURL: "/travel/draft.jpg", Hidden: true},
+		"/Private/secret.jpg": {Slug: "secret", AbsURL: "/private/secret.jpg", Hidden: true},
+	}
+	for srcPath, info := range images {
+		manifest.SetImage(srcPath, info)
+	}
+	manifest.SetSource("/Travel/tram.jpg", &SourceEntry{ModTime: day(3)})
+	manifest.SetSource("/Travel/café.jpg", &SourceEntry{ModTime: day(1)})
+	manifest.SetSource("/Travel/draft.jpg", &SourceEntry{ModTime: day(9)})
+	manifest.SetSource("/Private/secret.jpg", &SourceEntry{ModTime: day(9)})
+	for _, page := range []string{"/index.html", "/travel/index.html", "/travel/tram.html", "/travel/café.html",
+		"/travel/draft.html", "/private/index.html", "/private/secret.html", "/feed.xml"} {
+		manifest.AddPage(page)
+	}
+
+	writeXML("/"+sitemapFile, buildURLs(baseURL()))
+	got, err := ioutil.ReadFile(site.PubSiteDir + "/" + sitemapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != goldenSitemap {
+		t.Errorf("sitemap:\n%s\nwant:\n%s", got, goldenSitemap)
+	}
+}
+
+func TestRobots(t *testing.T) {
+	for _, key := range []string{ROBOTS_DISALLOW, ROBOTS_TEXT} {
+		defer viper.Set(key, viper.Get(key))
+	}
+	tests := []struct {
+		base     string
+		disallow []string
+		text     string
+		want     string
+	}{
+		{"", nil, "", "User-agent: *\nDisallow:\n"},
+		{"https://example.com", nil, "", "User-agent: *\nDisallow:\n\nSitemap: https://example.com/sitemap.xml\n"},
+		{"https://example.com", []string{"/private/", "/drafts/"}, "",
+			"User-agent: *\nDisallow: /private/\nDisallow: /drafts/\n\nSitemap: https://example.com/sitemap.xml\n"},
+		{"https://example.com", []string{"/private/"}, "User-agent: *\nDisallow: /\n", "User-agent: *\nDisallow: /\n"},
+	}
+	for _, test := range tests {
+		viper.Set(ROBOTS_DISALLOW, test.disallow)
+		viper.Set(ROBOTS_TEXT, test.text)
+		if got := robots(test.base); got != test.want {
+			t.Errorf("robots(%q) with disallow %v and text %q = %q, want %q", test.base, test.disallow, test.text,
+				got, test.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package build
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/gpitfield/relog"
+	"github.com/spf13/viper"
+)
+
+const (
+	SORT = "sort" // sort mode of every collection that doesn't set its own
+
+	sortManual     = "manual" // by ordering prefix, then capture date
+	descendingSort = "-desc"  // suffix reversing any sort mode, e.g. date-desc
+)
+
+// Less reports whether image a sorts before image b
+type Less func(a, b PrintInfo) bool
+
+var (
+	sorters = map[string]Less{
+		sortManual: manualLess,
+		"date":     func(a, b PrintInfo) bool { return a.Date.Before(b.Date) },
+		"filename": func(a, b PrintInfo) bool { return a.Filename < b.Filename },
+		"title":    func(a, b PrintInfo) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) },
+		"rating":   func(a, b PrintInfo) bool { return a.Rating < b.Rating },
+		"modified": func(a, b PrintInfo) bool { return a.ModTime.Before(b.ModTime) },
+	}
+	sortersMu sync.RWMutex
+)
+
+// RegisterSort adds a sort mode that collections can be listed in, replacing any of the same name
+func RegisterSort(mode string, less Less) {
+	sortersMu.Lock()
+	defer sortersMu.Unlock()
+	sorters[strings.ToLower(mode)] = less
+}
+
+// sorter returns the comparison of the given sort mode, reversed if it ends in -desc, falling back to manual if the mode
+// is unknown
+func sorter(mode string) Less {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode == "" {
+		mode = sortManual
+	}
+	sortersMu.RLock()
+	defer sortersMu.RUnlock()
+	if less, ok := sorters[mode]; ok {
+		return less
+	}
+	if less, ok := sorters[strings.TrimSuffix(mode, descendingSort)]; ok && strings.HasSuffix(mode, descendingSort) {
+		return func(a, b PrintInfo) bool { return less(b, a) }
+	}
+	log.Errorf("unknown sort mode %s, sorting manually", mode)
+	return manualLess
+}
+
+// sortImages orders images in the given sort mode, or the site's if it's empty, after first placing those named in
+// manual in the order listed. Images that compare equal keep their order, which is that of their file names.
+func sortImages(images []PrintInfo, mode string, manual []string) {
+	if mode == "" {
+		mode = viper.GetString(SORT)
+	}
+	less := sorter(mode)
+	position := func(info PrintInfo) int {
+		for i, name := range manual {
+			if matchesName(name, info) {
+				return i
+			}
+		}
+		return len(manual)
+	}
+	sort.SliceStable(images, func(i, j int) bool {
+		if pi, pj := position(images[i]), position(images[j]); pi != pj {
+			return pi < pj
+		}
+		return less(images[i], images[j])
+	})
+}
+
+// manualLess sorts images with an ordering prefix first, in its order, and then the rest by capture date
+func manualLess(a, b PrintInfo) bool {
+	if a.Order == 0 && b.Order == 0 {
+		return a.Date.Before(b.Date)
+	}
+	if b.Order == 0 {
+		return true
+	}
+	if a.Order == 0 {
+		return false
+	}
+	return a.Order < b.Order
+}
@@ -0,0 +1,116 @@
+package build
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// sortFixture returns images whose file names, titles, dates, ratings, modification times and ordering prefixes each
+// put them in a different order
+func sortFixture() []PrintInfo {
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC) }
+	return []PrintInfo{
+		{Filename: "_2_beach.jpg", FileURL: "beach", Title: "Beach", Order: 2, Date: day(3), Rating: 1, ModTime: day(9)},
+		{Filename: "cliff.jpg", FileURL: "cliff", Title: "cliff", Date: day(4), Rating: 5, ModTime: day(7)},
+		{Filename: "_1_dune.jpg", FileURL: "dune", Title: "Dune", Order: 1, Date: day(5), Rating: 3, ModTime: day(8)},
+		{Filename: "alley.jpg", FileURL: "alley", Title: "Alley", Date: day(2), Rating: 4, ModTime: day(6)},
+	}
+}
+
+// names returns the file names of the images, stripped of any ordering prefix
+func names(images []PrintInfo) (out []string) {
+	for _, info := range images {
+		out = append(out, info.FileURL)
+	}
+	return
+}
+
+func TestSortImages(t *testing.T) {
+	tests := []struct {
+		mode   string
+		manual []string
+		want   []string
+	}{
+		{"", nil, []string{"dune", "beach", "alley", "cliff"}},
+		{"manual", nil, []string{"dune", "beach", "alley", "cliff"}},
+		{"manual-desc", nil, []string{"cliff", "alley", "beach", "dune"}},
+		{"date", nil, []string{"alley", "beach", "cliff", "dune"}},
+		{"date-desc", nil, []string{"dune", "cliff", "beach", "alley"}},
+		{"filename", nil, []string{"dune", "beach", "alley", "cliff"}},
+		{"filename-desc", nil, []string{"cliff", "alley", "beach", "dune"}},
+		{"title", nil, []string{"alley", "beach", "cliff", "dune"}},
+		{"title-desc", nil, []string{"dune", "cliff", "beach", "alley"}},
+		{"rating", nil, []string{"beach", "dune", "alley", "cliff"}},
+		{"rating-desc", nil, []string{"cliff", "alley", "dune", "beach"}},
+		{"modified", nil, []string{"alley", "cliff", "dune", "beach"}},
+		{"modified-desc", nil, []string{"beach", "dune", "cliff", "alley"}},
+		{"Date-Desc", nil, []string{"dune", "cliff", "beach", "alley"}},
+		{"unknown", nil, []string{"dune", "beach", "alley", "cliff"}},
+		// images named in the manual order come first, in that order, and the rest follow in the sort mode
+		{"date", []string{"cliff.jpg", "_2_beach.jpg"}, []string{"cliff", "beach", "alley", "dune"}},
+		{"date", []string{"dune", "missing.jpg", "alley"}, []string{"dune", "alley", "beach", "cliff"}},
+		{"title-desc", []string{"beach.jpg"}, []string{"beach", "dune", "cliff", "alley"}},
+	}
+	for _, test := range tests {
+		images := sortFixture()
+		sortImages(images, test.mode, test.manual)
+		if got := names(images); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("sort %q with manual order %v: got %v, want %v", test.mode, test.manual, got, test.want)
+		}
+	}
+}
+
+func TestSortImagesSiteMode(t *testing.T) {
+	defer viper.Set(SORT, viper.GetString(SORT))
+	viper.Set(SORT, "rating-desc")
+	images := sortFixture()
+	sortImages(images, "", nil)
+	if got, want := names(images), []string{"cliff", "alley", "dune", "beach"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sort in the site's mode: got %v, want %v", got, want)
+	}
+	images = sortFixture()
+	sortImages(images, "date", nil)
+	if got, want := names(images), []string{"alley", "beach", "cliff", "dune"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sort in a collection's mode: got %v, want %v", got, want)
+	}
+}
+
+// TestManualLess checks that an image with an ordering prefix always sorts before one without, whatever their dates,
+// which the comparison once got wrong by only checking whether the second image had a prefix
+func TestManualLess(t *testing.T) {
+	early, late := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		a, b PrintInfo
+		want bool
+	}{
+		{PrintInfo{Order: 1, Date: late}, PrintInfo{Date: early}, true},
+		{PrintInfo{Date: early}, PrintInfo{Order: 1, Date: late}, false},
+		{PrintInfo{Order: 1, Date: late}, PrintInfo{Order: 2, Date: early}, true},
+		{PrintInfo{Order: 2, Date: early}, PrintInfo{Order: 1, Date: late}, false},
+		{PrintInfo{Date: early}, PrintInfo{Date: late}, true},
+		{PrintInfo{Date: late}, PrintInfo{Date: early}, false},
+	}
+	for i, test := range tests {
+		if got := manualLess(test.a, test.b); got != test.want {
+			t.Errorf("%d: manualLess(order %d %s, order %d %s) = %t, want %t", i, test.a.Order,
+				test.a.Date.Format("2006"), test.b.Order, test.b.Date.Format("2006"), got, test.want)
+		}
+	}
+}
+
+func TestRegisterSort(t *testing.T) {
+	defer func() {
+		sortersMu.Lock()
+		delete(sorters, "length")
+		sortersMu.Unlock()
+	}()
+	RegisterSort("Length", func(a, b PrintInfo) bool { return len(a.Filename) < len(b.Filename) })
+	images := sortFixture()
+	sortImages(images, "length-desc", nil)
+	if got, want := names(images), []string{"beach", "dune", "cliff", "alley"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sort by a registered mode: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+package build
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gpitfield/filmstrip/site"
+	"github.com/spf13/viper"
+)
+
+const (
+	TAGS = "tags" // whether to publish a page for each keyword, listing its images from across the site
+)
+
+// Tag is a keyword and the published images it's given to
+type Tag struct {
+	Name   string // as first written among the images
+	Slug   string // name of the tag's directory under tags
+	Images []PrintInfo
+}
+
+// Count returns the number of images with the tag
+func (t Tag) Count() int { return len(t.Images) }
+
+// tagging reports whether tag pages are published, which they are unless turned off
+func tagging() bool {
+	return !viper.IsSet(TAGS) || viper.GetBool(TAGS)
+}
+
+// tagSlug returns the directory name of a tag, its letters and digits in lower case with anything else between them
+// replaced by a dash, so that "Black & White" and "black-white" are the same tag
+func tagSlug(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, "-")
+}
+
+// tagLinks returns a link to the tag page of each of the given keywords
+func tagLinks(keywords []string) (links []NavInfo) {
+	if !tagging() {
+		return
+	}
+	for _, keyword := range keywords {
+		if slug := tagSlug(keyword); slug != "" {
+			links = append(links, NavInfo{Name: keyword, Link: "/" + site.TagsDir + "/" + slug + "/index.html"})
+		}
+	}
+	return
+}
+
+// collectTags groups the given images by keyword, in order of tag name
+func collectTags(images []PrintInfo) (tags []*Tag) {
+	bySlug := map[string]*Tag{}
+	for _, info := range images {
+		seen := map[string]bool{}
+		for _, keyword := range info.Keywords {
+			slug := tagSlug(keyword)
+			if slug == "" || seen[slug] {
+				continue
+			}
+			seen[slug] = true
+			tag, ok := bySlug[slug]
+			if !ok {
+				tag = &Tag{Name: strings.TrimSpace(keyword), Slug: slug}
+				bySlug[slug] = tag
+				tags = append(tags, tag)
+			}
+			tag.Images = append(tag.Images, info)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Slug < tags[j].Slug })
+	return
+}
+
+// buildTags publishes an index of every keyword given to a published image, and a gallery of the images with each one,
+// newest first, linking to their pages in their own collections
+func buildTags() {
+	if !tagging() {
+		return
+	}
+	tags := collectTags(manifest.Published())
+	if len(tags) == 0 {
+		return
+	}
+	for _, tag := range tags {
+		linkCollections(tag.Images)
+		sortImages(tag.Images, "date-desc", nil)
+		outPath := "/" + site.TagsDir + "/" + tag.Slug
+		checkErr(os.MkdirAll(site.PubSiteDir+outPath, os.ModeDir|os.ModePerm))
+		gallery := renderGallery(tag.Name, CollectionInfo{}, tag.Images, false)
+		writePage(outPath+"/index.html", gallery.Bytes())
+	}
+	writePage("/"+site.TagsDir+"/index.html", renderTags(tags).Bytes())
+}
@@ -0,0 +1,163 @@
+package build
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gpitfield/filmstrip/asset"
+	log "github.com/gpitfield/relog"
+)
+
+const (
+	xmpExtension = ".xmp"
+	rdfNS        = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+// xmpPrefixes are the usual prefixes of the XMP namespaces read, which properties are keyed by whatever prefix a
+// packet itself declares
+var xmpPrefixes = map[string]string{
+	"http://purl.org/dc/elements/1.1/":            "dc",
+	"http://ns.adobe.com/xap/1.0/":                "xmp",
+	"http://ns.adobe.com/photoshop/1.0/":          "photoshop",
+	"http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/": "Iptc4xmpCore",
+	"http://ns.adobe.com/exif/1.0/":               "exif",
+}
+
+// xmpProperties are the values of the simple and array properties of an XMP packet, keyed by prefix:name
+type xmpProperties map[string][]string
+
+// readXMPSidecar reads the XMP sidecar of the source image at fullPath, named either for the image without its
+// extension, as Lightroom does, or with .xmp appended
+func readXMPSidecar(fullPath string) []byte {
+	base := strings.TrimSuffix(fullPath, filepath.Ext(fullPath))
+	for _, path := range []string{base + xmpExtension, fullPath + xmpExtension} {
+		b, err := ioutil.ReadFile(path)
+		if err == nil {
+			return b
+		}
+		if !os.IsNotExist(err) {
+			log.Error(err)
+		}
+	}
+	return nil
+}
+
+// xmpCaption returns the descriptive metadata in the XMP of the source image at fullPath, read from in, with that of its
+// sidecar taking precedence
+func xmpCaption(fullPath string, in io.ReadSeeker) Caption {
+	embedded := parseXMP(asset.ReadXMP(in)).caption()
+	return parseXMP(readXMPSidecar(fullPath)).caption().merge(embedded)
+}
+
+// parseXMP reads the properties of an XMP packet. Properties may be attributes of rdf:Description or elements within
+// it, holding text or an rdf:Alt, rdf:Bag or rdf:Seq of rdf:li items; structured properties are not read.
+func parseXMP(packet []byte) (props xmpProperties) {
+	props = xmpProperties{}
+	if len(packet) == 0 {
+		return
+	}
+	var (
+		decoder  = xml.NewDecoder(bytes.NewReader(packet))
+		stack    []xml.Name
+		property string // property being read, if any
+		depth    int    // depth of the property element
+		text     strings.Builder
+		items    int  // rdf:li items read for the property
+		nested   bool // whether the property is a structure, which isn't read
+	)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			log.Errorf("ignoring malformed XMP: %s", err.Error())
+			return
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+			switch {
+			case property == "" && isDescription(t.Name):
+				for _, attr := range t.Attr {
+					if key := xmpKey(attr.Name); key != "" {
+						props[key] = append(props[key], attr.Value)
+					}
+				}
+			case property == "" && len(stack) > 1 && isDescription(stack[len(stack)-2]):
+				if property = xmpKey(t.Name); property != "" {
+					depth, items, nested = len(stack), 0, false
+					text.Reset()
+				}
+			case property != "" && t.Name.Space == rdfNS && t.Name.Local == "li":
+				text.Reset()
+			case property != "" && t.Name.Space != rdfNS:
+				nested = true
+			}
+		case xml.CharData:
+			if property != "" {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			switch {
+			case property != "" && !nested && t.Name.Space == rdfNS && t.Name.Local == "li":
+				if value := strings.TrimSpace(text.String()); value != "" {
+					props[property] = append(props[property], value)
+				}
+				items++
+				text.Reset()
+			case property != "" && len(stack) == depth:
+				if value := strings.TrimSpace(text.String()); !nested && items == 0 && value != "" {
+					props[property] = append(props[property], value)
+				}
+				property = ""
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+}
+
+// first returns the first value of the property, or "" if it has none
+func (p xmpProperties) first(key string) string {
+	if values := p[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// caption returns the descriptive metadata of the packet
+func (p xmpProperties) caption() (c Caption) {
+	c.Title = p.first("dc:title")
+	c.Description = p.first("dc:description")
+	c.Copyright = p.first("dc:rights")
+	c.Keywords = p["dc:subject"]
+	c.Rating, _ = strconv.Atoi(p.first("xmp:Rating"))
+	if c.Rating < 0 { // -1 marks a rejected image, which isn't a rating to show
+		c.Rating = 0
+	}
+	c.Label = p.first("xmp:Label")
+	c.Byline = p.first("dc:creator")
+	c.Credit = p.first("photoshop:Credit")
+	c.Location = joinLocation(p.first("Iptc4xmpCore:Location"), p.first("photoshop:City"), p.first("photoshop:State"),
+		p.first("photoshop:Country"))
+	return
+}
+
+// xmpKey returns the prefix:name key of a property, or "" if it isn't in one of the namespaces read
+func xmpKey(name xml.Name) string {
+	if prefix, ok := xmpPrefixes[name.Space]; ok {
+		return prefix + ":" + name.Local
+	}
+	return ""
+}
+
+func isDescription(name xml.Name) bool {
+	return name.Space == rdfNS && name.Local == "Description"
+}
@@ -0,0 +1,78 @@
+package build
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// xmpPacket wraps the properties of an rdf:Description in an XMP packet
+func xmpPacket(attrs, elements string) string {
+	return `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" ` +
+		`xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" ` +
+		`xmlns:Iptc4xmpCore="http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/" ` + attrs + `>` + elements +
+		`</rdf:Description></rdf:RDF></x:xmpmeta>`
+}
+
+func TestParseXMP(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet string
+		want   Caption
+	}{
+		{"empty", "", Caption{}},
+		{"malformed", "<x:xmpmeta><rdf:RDF>", Caption{}},
+		{"lightroom", xmpPacket(`xmp:Rating="4" xmp:Label="Red" photoshop:City="Lisbon" photoshop:Country="Portugal"`,
+			`<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Tram 28</rdf:li></rdf:Alt></dc:title>`+
+				`<dc:description><rdf:Alt><rdf:li xml:lang="x-default"> Up the hill </rdf:li></rdf:Alt></dc:description>`+
+				`<dc:rights><rdf:Alt><rdf:li xml:lang="x-default">© Jo</rdf:li></rdf:Alt></dc:rights>`+
+				`<dc:subject><rdf:Bag><rdf:li>tram</rdf:li><rdf:li>street</rdf:li></rdf:Bag></dc:subject>`+
+				`<Iptc4xmpCore:Location>Alfama</Iptc4xmpCore:Location>`),
+			Caption{Title: "Tram 28", Description: "Up the hill", Copyright: "© Jo", Keywords: []string{"tram", "street"},
+				Rating: 4, Label: "Red", Location: "Alfama, Lisbon, Portugal"}},
+		{"rejected", xmpPacket(`xmp:Rating="-1"`, ""), Caption{}},
+		{"repeated location", xmpPacket(`photoshop:City="Singapore" photoshop:Country="Singapore"`, ""),
+			Caption{Location: "Singapore"}},
+		{"structure", xmpPacket("",
+			`<Iptc4xmpCore:CreatorContactInfo><rdf:Description><Iptc4xmpCore:CiAdrCity>Lisbon</Iptc4xmpCore:CiAdrCity>`+
+				`</rdf:Description></Iptc4xmpCore:CreatorContactInfo>`), Caption{}},
+	}
+	for _, test := range tests {
+		if got := parseXMP([]byte(test.packet)).caption(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestXMPCaptionSidecar checks that a sidecar's fields take precedence over those embedded in the image, which fill in
+// whatever the sidecar leaves out
+func TestXMPCaptionSidecar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filmstrip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	embedded := append([]byte("http://ns.adobe.com/xap/1.0/\x00"),
+		xmpPacket(`xmp:Rating="2"`, `<dc:title><rdf:Alt><rdf:li>Embedded</rdf:li></rdf:Alt></dc:title>`)...)
+	image := []byte{0xff, 0xd8, 0xff, 0xe1}
+	image = binary.BigEndian.AppendUint16(image, uint16(len(embedded)+2))
+	image = append(append(image, embedded...), 0xff, 0xd9)
+
+	fullPath := filepath.Join(dir, "tram.jpg")
+	if got := xmpCaption(fullPath, bytes.NewReader(image)); got.Title != "Embedded" || got.Rating != 2 {
+		t.Errorf("without a sidecar: got %+v, want the embedded title and rating", got)
+	}
+	sidecar := xmpPacket(`xmp:Label="Green"`, `<dc:title><rdf:Alt><rdf:li>Sidecar</rdf:li></rdf:Alt></dc:title>`)
+	if err = ioutil.WriteFile(filepath.Join(dir, "tram.xmp"), []byte(sidecar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := Caption{Title: "Sidecar", Rating: 2, Label: "Green"}
+	if got := xmpCaption(fullPath, bytes.NewReader(image)); !reflect.DeepEqual(got, want) {
+		t.Errorf("with a sidecar: got %+v, want %+v", got, want)
+	}
+}
@@ -3,17 +3,29 @@ package cmd
 import (
 	"github.com/gpitfield/filmstrip/build"
 	"github.com/gpitfield/filmstrip/deploy"
+	"github.com/gpitfield/filmstrip/serve"
+	"github.com/gpitfield/filmstrip/watch"
 	"github.com/spf13/cobra"
 )
 
-var force bool
+var (
+	force  bool
+	dryRun bool
+	addr   string
+	push   bool
+)
 
 var RootCmd = &cobra.Command{
-	Use:   "filmstrip",
-	Short: "Generate and deploy the filmstrip site",
-	Run: func(cmd *cobra.Command, args []string) {
-		build.Build(force)
+	Use:           "filmstrip",
+	Short:         "Generate and deploy the filmstrip site",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := build.Build(force, false); err != nil {
+			return err
+		}
 		deploy.Deploy(force)
+		return nil
 	},
 }
 
@@ -26,17 +38,52 @@ var dpl = &cobra.Command{
 }
 
 var bld = &cobra.Command{
-	Use:   "build",
-	Short: "Generate the 'site' folder.",
+	Use:           "build",
+	Short:         "Generate the 'site' folder.",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return build.Build(force, dryRun)
+	},
+}
+
+var prune = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove files from the 'site' folder that the last build did not generate.",
+	Run: func(cmd *cobra.Command, args []string) {
+		build.Prune(dryRun)
+	},
+}
+
+var srv = &cobra.Command{
+	Use:   "serve",
+	Short: "Preview the 'site' folder locally, rebuilding and reloading as it changes.",
+	Run: func(cmd *cobra.Command, args []string) {
+		serve.Serve(addr, force)
+	},
+}
+
+var wtch = &cobra.Command{
+	Use:   "watch",
+	Short: "Rebuild the 'site' folder as the source images change, optionally deploying each change.",
 	Run: func(cmd *cobra.Command, args []string) {
-		build.Build(force)
+		watch.Run(push, force)
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(dpl)
 	RootCmd.AddCommand(bld)
+	RootCmd.AddCommand(prune)
+	RootCmd.AddCommand(srv)
+	RootCmd.AddCommand(wtch)
 	dpl.Flags().BoolVarP(&force, "force", "f", false, "force upload even if files exist")
 	bld.Flags().BoolVarP(&force, "force", "f", false, "force regenerate even if files exist")
+	bld.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "list stale files instead of deleting them")
+	prune.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "list stale files instead of deleting them")
+	srv.Flags().BoolVarP(&force, "force", "f", false, "force regenerate even if files exist")
+	srv.Flags().StringVarP(&addr, "addr", "a", "localhost:8000", "address to serve the site on")
+	wtch.Flags().BoolVarP(&force, "force", "f", false, "force regenerate and upload even if files exist")
+	wtch.Flags().BoolVarP(&push, "deploy", "d", false, "deploy the changed files after each rebuild")
 	RootCmd.Flags().BoolVarP(&force, "force", "f", false, "force regenerate and upload even if files exist")
 }
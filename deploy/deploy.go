@@ -46,6 +46,9 @@ func GetPaths(prefix string) (paths []string) {
 		log.Error(err)
 	}
 	for _, f := range files {
+		if f.Name() == site.ManifestFile {
+			continue
+		}
 		if f.IsDir() {
 			paths = append(paths, GetPaths(prefix+"/"+f.Name())...)
 			continue
@@ -55,6 +58,38 @@ func GetPaths(prefix string) (paths []string) {
 	return paths
 }
 
+// DeployPaths deploys only the given paths, relative to the public site, forcing overwrite if force is true
+func DeployPaths(paths []string, force bool) {
+	if len(paths) == 0 {
+		return
+	}
+	if viper.GetString(SITE_DRIVER) == "" {
+		log.Warnf("please set a value for %s in the config file", SITE_DRIVER)
+		return
+	}
+	start := time.Now()
+	workers := viper.GetInt("workers")
+	if workers == 0 {
+		workers = 1
+	}
+	jobs := make(chan PutJob)
+	done := make(chan bool)
+	for i := 0; i < workers; i++ {
+		go PutFiles(jobs, done)
+	}
+	for _, path := range paths {
+		if path == "/"+site.ManifestFile {
+			continue
+		}
+		jobs <- PutJob{site.PubSiteDir, path, force}
+	}
+	close(jobs)
+	for i := 0; i < workers; i++ {
+		<-done
+	}
+	log.Infof("%d files deployed in %v", len(paths), time.Since(start))
+}
+
 func DeployDirs(localPrefix string, path string, force bool, jobs chan PutJob, done chan bool) {
 	closer := false
 	if jobs == nil {
@@ -76,6 +111,9 @@ func DeployDirs(localPrefix string, path string, force bool, jobs chan PutJob, d
 		log.Error(err)
 	}
 	for _, f := range files {
+		if f.Name() == site.ManifestFile {
+			continue
+		}
 		if f.IsDir() {
 			DeployDirs(localPrefix, path+"/"+f.Name(), force, jobs, done)
 			continue
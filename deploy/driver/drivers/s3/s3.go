@@ -58,10 +58,30 @@ func (s s3Driver) PutFile(localPrefix string, path string, force bool) (err erro
 	case "js":
 		contentType = "text/javascript"
 		break
+	case "xml":
+		contentType = "application/xml"
+		maxAge = "max-age=0"
+		break
+	case "txt":
+		contentType = "text/plain"
+		maxAge = "max-age=0"
+		break
+	case "geojson":
+		contentType = "application/geo+json"
+		maxAge = "max-age=0"
+		break
 	case "jpg":
 		contentType = "image/jpeg"
 		maxAge = "max-age=3600"
 		break
+	case "webp":
+		contentType = "image/webp"
+		maxAge = "max-age=3600"
+		break
+	case "avif":
+		contentType = "image/avif"
+		maxAge = "max-age=3600"
+		break
 	}
 
 	params := &s3.PutObjectInput{
@@ -0,0 +1,164 @@
+// Package serve previews the public site over HTTP, rebuilding it as its sources change and reloading any
+// browsers viewing it
+package serve
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gpitfield/filmstrip/build"
+	"github.com/gpitfield/filmstrip/site"
+	"github.com/gpitfield/filmstrip/watch"
+	log "github.com/gpitfield/relog"
+	"github.com/spf13/viper"
+)
+
+const (
+	ReloadPath = "/_filmstrip/reload" // server-sent events endpoint that tells browsers to reload
+	debounce   = 300 * time.Millisecond
+)
+
+// reloadScript is injected into every served page, and reloads it when the site is rebuilt
+var reloadScript = []byte(`<script>new EventSource("` + ReloadPath + `").onmessage = function() { window.location.reload() }</script>`)
+
+// Serve builds the site and serves it at addr, rebuilding whenever the source images, templates or config change
+func Serve(addr string, force bool) {
+	if err := build.Build(force, false); err != nil {
+		log.Fatal(err)
+	}
+	reload := &reloader{clients: map[chan bool]bool{}}
+	w, err := watch.New(debounce, viper.GetString("source-dir"), site.SiteDir+"/"+site.TemplatesDir, viper.ConfigFileUsed())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer w.Close()
+	go rebuild(w, reload)
+
+	mux := http.NewServeMux()
+	mux.Handle(ReloadPath, reload)
+	mux.Handle("/", pages{http.FileServer(http.Dir(site.PubSiteDir))})
+	log.Infof("serving %s at http://%s", site.PubSiteDir, addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+// rebuild rebuilds the collections affected by each batch of changes, or the whole site with its HTML regenerated
+// if the templates or config changed, and then reloads any open pages
+func rebuild(w *watch.Watcher, reload *reloader) {
+	templates, _ := filepath.Abs(site.SiteDir + "/" + site.TemplatesDir)
+	config, _ := filepath.Abs(viper.ConfigFileUsed())
+	for changed := range w.Changes {
+		var force bool
+		for _, p := range changed {
+			switch {
+			case p == config:
+				source := viper.GetString("source-dir")
+				if err := build.ReloadConfig(); err != nil {
+					log.Error(err)
+					continue
+				}
+				if s := viper.GetString("source-dir"); s != source {
+					if err := w.Add(s); err != nil {
+						log.Error(err)
+					}
+				}
+				force = true
+			case strings.HasPrefix(p, templates):
+				site.ReloadTemplates()
+				force = true
+			}
+		}
+		log.Infof("rebuilding for %d changes", len(changed))
+		if force {
+			if err := build.Build(force, false); err != nil {
+				log.Error(err)
+			}
+		} else {
+			build.BuildChanged(changed, false)
+		}
+		reload.reload()
+	}
+}
+
+// pages serves the public site, injecting the reload script into HTML pages
+type pages struct {
+	files http.Handler
+}
+
+func (p pages) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name += "/index.html"
+	}
+	if !strings.HasSuffix(name, ".html") {
+		p.files.ServeHTTP(w, r)
+		return
+	}
+	b, err := ioutil.ReadFile(site.PubSiteDir + name)
+	if err != nil {
+		p.files.ServeHTTP(w, r)
+		return
+	}
+	if i := bytes.LastIndex(b, []byte("</body>")); i >= 0 {
+		b = append(b[:i], append(reloadScript, b[i:]...)...)
+	} else {
+		b = append(b, reloadScript...)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(b)
+}
+
+// reloader holds open a server-sent events stream to each browser viewing the site
+type reloader struct {
+	mu      sync.Mutex
+	clients map[chan bool]bool
+}
+
+func (rl *reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	ch := make(chan bool, 1)
+	rl.mu.Lock()
+	rl.clients[ch] = true
+	rl.mu.Unlock()
+	defer func() {
+		rl.mu.Lock()
+		delete(rl.clients, ch)
+		rl.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-ch:
+			fmt.Fprint(w, "data: reload\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// reload tells every connected browser to reload
+func (rl *reloader) reload() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for ch := range rl.clients {
+		select {
+		case ch <- true:
+		default: // a reload is already pending
+		}
+	}
+}
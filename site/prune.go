@@ -0,0 +1,70 @@
+package site
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/gpitfield/relog"
+)
+
+// Prune removes every file under the public site directory that is not in expected, other than the scaffold
+// directories, and then any directories left empty. Paths in expected are relative to PubSiteDir, with a leading
+// slash. If dryRun is true nothing is removed and the stale files are only listed. Prune returns the stale paths.
+func Prune(expected map[string]bool, dryRun bool) (stale []string) {
+	var dirs []string
+	err := filepath.Walk(PubSiteDir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(filepath.ToSlash(path), PubSiteDir)
+		if f.IsDir() {
+			if rel == "" {
+				return nil
+			}
+			for _, dir := range ScaffoldDirs {
+				if rel == "/"+dir {
+					return filepath.SkipDir
+				}
+			}
+			dirs = append(dirs, path)
+			return nil
+		}
+		if !expected[rel] {
+			stale = append(stale, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	for _, rel := range stale {
+		if dryRun {
+			log.Printf("would delete %s", rel)
+			continue
+		}
+		log.Infof("deleting %s", rel)
+		if err := os.Remove(PubSiteDir + rel); err != nil {
+			log.Error(err)
+		}
+	}
+	if dryRun {
+		log.Infof("%d files would be pruned", len(stale))
+		return
+	}
+
+	// remove emptied directories deepest first
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if files, err := ioutil.ReadDir(dir); err == nil && len(files) == 0 {
+			if err := os.Remove(dir); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+	return
+}
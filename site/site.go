@@ -18,17 +18,30 @@ import (
 const (
 	PubSiteDir    = "public"
 	SiteDir       = "site"
+	TemplatesDir  = "templates"
 	CSSStylesDir  = "css"
 	JavaScriptDir = "js"
 	ImagesDir     = "images"
 	AboutDir      = "about"
+	TagsDir       = "tags"
+	ArchiveDir    = "archive"
+	MapDir        = "map"
 	FilmstripCSS  = "filmstrip.css"
+	ManifestFile  = ".filmstrip-manifest.json" // build manifest, kept in PubSiteDir but never deployed
 )
 
 var Templates *template.Template
 
+// ScaffoldDirs are the public site directories populated by Scaffold rather than the build, and never pruned
+var ScaffoldDirs = []string{CSSStylesDir, JavaScriptDir}
+
 func init() {
-	Templates = loadTemplates("detail.html", "bootstrap.html", "nav.html", "gallery.html", "cover.html", "bottom-nav.html", "about.html", "filmstrip.css")
+	ReloadTemplates()
+}
+
+// ReloadTemplates re-parses the site templates, so that a long-running process picks up edits to them
+func ReloadTemplates() {
+	Templates = loadTemplates("detail.html", "bootstrap.html", "nav.html", "gallery.html", "cover.html", "bottom-nav.html", "about.html", "tags.html", "archive.html", "map.html", "filmstrip.css")
 }
 
 // Escape returns the lowercased, dash-spaced, and escaped version of the input
@@ -40,75 +53,6 @@ func LowerDash(in string) string {
 	return strings.Replace(strings.ToLower(in), " ", "-", -1)
 }
 
-// Flush removes any invalid/remnant folders from the local public site folder
-func Flush(prefix, name string) {
-	var (
-		err                  error
-		sourceLocation       = viper.GetString("source-dir")
-		sourceDirs, siteDirs []os.FileInfo
-		fullPath             = "/"
-	)
-	protectedSubDirs := []string{ImagesDir, AboutDir, CSSStylesDir, JavaScriptDir}
-	if prefix != "" {
-		fullPath = "/" + prefix + "/" + name
-	} else if name != "" {
-		fullPath = "/" + name
-	}
-	sourceDirs, err = ioutil.ReadDir(sourceLocation + fullPath)
-	checkErr(err)
-	sourceDirs, err = ioutil.ReadDir(PubSiteDir + LowerDash(fullPath))
-	checkErr(err)
-
-	for _, dir := range sourceDirs {
-		if dir.IsDir() {
-			protectedSubDirs = append(protectedSubDirs, dir.Name())
-		}
-	}
-
-	for _, dir := range siteDirs {
-		if !dir.IsDir() {
-			continue
-		}
-		remove := true
-		for _, sub := range protectedSubDirs {
-			if sub == dir.Name() {
-				remove = false
-				break
-			}
-		}
-		if remove {
-			err := os.RemoveAll(PubSiteDir + LowerDash(fullPath) + "/" + dir.Name())
-			if err != nil {
-				log.Error(err)
-			}
-		}
-	}
-}
-
-func FlushInvalid(dir string, validFiles map[string]bool) {
-	files, err := ioutil.ReadDir(dir)
-	if err != nil {
-		log.Error(err)
-	}
-	for _, f := range files {
-		if f.IsDir() {
-			continue
-		}
-		if !validFiles[f.Name()] {
-			remove := true
-			for key, _ := range validFiles {
-				if strings.HasPrefix(f.Name(), key) {
-					remove = false
-					break
-				}
-			}
-			if remove {
-				os.Remove(dir + "/" + f.Name()) // delete invalid file
-			}
-		}
-	}
-}
-
 // Scaffold copies CSS, JS and similar scaffolding to the local public site directory
 // TODO: these assets should have a hash set as part of their filename to enable more effective caching/busting
 func Scaffold() {
@@ -0,0 +1,156 @@
+// Package watch notifies of changes to the site's source images, templates and config
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gpitfield/filmstrip/build"
+	"github.com/gpitfield/filmstrip/deploy"
+	log "github.com/gpitfield/relog"
+	"github.com/spf13/viper"
+)
+
+const (
+	WATCH_DELAY  = "watch-delay"
+	defaultDelay = 2 * time.Second
+)
+
+// Run builds the site and then watches the source directory until interrupted, rebuilding only the collections
+// affected by each burst of changes. If deployChanges is true, the site is deployed once built, and after that
+// only the files each rebuild writes are deployed.
+func Run(deployChanges, force bool) {
+	if err := build.Build(force, false); err != nil {
+		log.Fatal(err)
+	}
+	if deployChanges {
+		deploy.Deploy(force)
+	}
+	delay := viper.GetDuration(WATCH_DELAY)
+	if delay == 0 {
+		delay = defaultDelay
+	}
+	w, err := New(delay, viper.GetString("source-dir"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer w.Close()
+	log.Infof("watching %s", viper.GetString("source-dir"))
+	for changed := range w.Changes {
+		log.Infof("rebuilding for %d changes", len(changed))
+		written, pruned := build.BuildChanged(changed, force)
+		if !deployChanges {
+			continue
+		}
+		deploy.DeployPaths(written, force)
+		if len(pruned) > 0 {
+			deploy.Flush() // remove the pruned files from the site too
+		}
+	}
+}
+
+// Watcher watches files and directory trees, and delivers the paths changed within them on Changes in batches,
+// once no further changes have been seen for its delay
+type Watcher struct {
+	Changes chan []string
+	fs      *fsnotify.Watcher
+	delay   time.Duration
+}
+
+// New returns a Watcher of the given paths, skipping any that don't exist. Directories are watched recursively,
+// including any created later.
+func New(delay time.Duration, paths ...string) (w *Watcher, err error) {
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	w = &Watcher{
+		Changes: make(chan []string),
+		fs:      fs,
+		delay:   delay,
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			log.Warnf("not watching %s: %s", path, err.Error())
+			continue
+		}
+		if err = w.Add(path); err != nil {
+			fs.Close()
+			return nil, err
+		}
+	}
+	go w.run()
+	return
+}
+
+// Add watches path, recursively if it is a directory. Changed paths are always reported as absolute paths.
+func (w *Watcher) Add(path string) (err error) {
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return
+	}
+	return filepath.Walk(path, func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !f.IsDir() && p != path {
+			return nil
+		}
+		return w.fs.Add(p)
+	})
+}
+
+// Close stops watching, and closes Changes
+func (w *Watcher) Close() error {
+	return w.fs.Close()
+}
+
+func (w *Watcher) run() {
+	var (
+		pending = map[string]bool{}
+		timer   = time.NewTimer(w.delay)
+	)
+	timer.Stop()
+	defer close(w.Changes)
+	for {
+		select {
+		case ev, ok := <-w.fs.Events:
+			if !ok {
+				return
+			}
+			if ignored(ev.Name) || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			if ev.Op&fsnotify.Create == fsnotify.Create {
+				if f, err := os.Stat(ev.Name); err == nil && f.IsDir() {
+					if err := w.Add(ev.Name); err != nil {
+						log.Error(err)
+					}
+				}
+			}
+			pending[ev.Name] = true
+			timer.Reset(w.delay)
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return
+			}
+			log.Error(err)
+		case <-timer.C:
+			var changed []string
+			for path := range pending {
+				changed = append(changed, path)
+			}
+			pending = map[string]bool{}
+			w.Changes <- changed
+		}
+	}
+}
+
+// ignored reports whether path is a hidden, temporary or backup file that should not trigger a rebuild
+func ignored(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~")
+}